@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+//wakes probeLoop up early, buffered so requests made during a scan coalesce into one rescan
+var rescanRequests = make(chan struct{}, 1)
+
+//starts a scan right away, authenticated with "Authorization: Bearer <admin token>"
+func handleRescanRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(*adminTokenFlag)) != 1 {
+		http.Error(w, http.StatusText(401), 401)
+		return
+	}
+
+	select {
+	case rescanRequests <- struct{}{}:
+	default: //a rescan is already pending
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed assets
+var embeddedAssets embed.FS
+
+//the assets the status page is served from, see loadAssets
+var assets fs.FS
+
+//content hashes of the assets by path, see assetURL
+var assetHashes = map[string]string{}
+
+//embedded files have no modification time, they're served as last modified when the process started
+var assetsModTime = time.Now()
+
+//uses the embedded assets unless a directory to read them from was given
+func loadAssets() (fs.FS, error) {
+	if *assetsDirFlag != "" {
+		return os.DirFS(*assetsDirFlag), nil
+	}
+
+	return fs.Sub(embeddedAssets, "assets")
+}
+
+//hashes every asset up front so pages can reference them by content
+func hashAssets() error {
+	return fs.WalkDir(assets, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		hash, err := hashAsset(name)
+		if err != nil {
+			return err
+		}
+
+		assetHashes[name] = hash
+		return nil
+	})
+}
+
+func hashAsset(name string) (string, error) {
+	data, err := fs.ReadFile(assets, name)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])[:10], nil
+}
+
+//returns the url of an asset with its content hash appended, so it can be cached for long
+//assets are hashed on every call in dev mode, as they may be edited while running
+func assetURL(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	hash, ok := assetHashes[name]
+	if *devFlag {
+		var err error
+		hash, err = hashAsset(name)
+		ok = err == nil
+	}
+
+	if !ok {
+		return "/" + name
+	}
+	return "/" + name + "?v=" + hash
+}
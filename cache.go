@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+//a response rendered from the status of a single scan
+type cachedResponse struct {
+	lastScan       int64
+	scanInProgress bool
+	data           []byte
+}
+
+//responses only change once per scan, so they're rendered once and reused until the next one
+//the last ping strings are as old as the cached response, which is fine at minute granularity
+var (
+	responseCache      = map[string]cachedResponse{}
+	responseCacheMutex sync.Mutex
+)
+
+//returns the cached response for key, or renders it if a new scan has been published since
+//the lock is held while rendering so concurrent misses don't all render the same response
+func getCachedResponse(key string, render func(current toxStatus) ([]byte, error)) ([]byte, error) {
+	current, _ := status.Load().(toxStatus)
+
+	responseCacheMutex.Lock()
+	defer responseCacheMutex.Unlock()
+
+	if cached, ok := responseCache[key]; ok && cached.lastScan == current.LastScan && cached.scanInProgress == current.ScanInProgress {
+		return cached.data, nil
+	}
+
+	snapshot := getStatus()
+	data, err := render(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	responseCache[key] = cachedResponse{snapshot.LastScan, snapshot.ScanInProgress, data}
+	return data, nil
+}
+
+//like writeJSON, but the marshaled value is cached per scan
+func writeCachedJSON(w http.ResponseWriter, r *http.Request, key string, value func(current toxStatus) interface{}) {
+	data, err := getCachedResponse(key, func(current toxStatus) ([]byte, error) {
+		return json.Marshal(value(current))
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	writeWithETag(w, r, data, "application/json; charset=utf-8")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+//options of the config file, named after their flags, see -config
+//durations are strings like "60s", options that are left out keep their flag value
+type Config struct {
+	HTTPPort       *int     `json:"http-port,omitempty"`
+	Refresh        *string  `json:"refresh,omitempty"`
+	UDPTimeout     *string  `json:"udp-timeout,omitempty"`
+	TCPTimeout     *string  `json:"tcp-timeout,omitempty"`
+	DialTimeout    *string  `json:"dial-timeout,omitempty"`
+	WikiURL        *string  `json:"wiki-url,omitempty"`
+	JSONURL        *string  `json:"json-url,omitempty"`
+	Sources        *string  `json:"sources,omitempty"`
+	AssetsDir      *string  `json:"assets-dir,omitempty"`
+	Include        *string  `json:"include,omitempty"`
+	Exclude        *string  `json:"exclude,omitempty"`
+	ScanTimeout    *string  `json:"scan-timeout,omitempty"`
+	Workers        *int     `json:"workers,omitempty"`
+	MaxDials       *int     `json:"max-dials,omitempty"`
+	RateLimit      *float64 `json:"rate-limit,omitempty"`
+	RateBurst      *int     `json:"rate-burst,omitempty"`
+	Attempts       *int     `json:"attempts,omitempty"`
+	CORSOrigin     *string  `json:"cors-origin,omitempty"`
+	Dev            *bool    `json:"dev,omitempty"`
+	LogLevel       *string  `json:"log-level,omitempty"`
+	DB             *string  `json:"db,omitempty"`
+	GeoIPDB        *string  `json:"geoip-db,omitempty"`
+	TLSCert        *string  `json:"tls-cert,omitempty"`
+	TLSKey         *string  `json:"tls-key,omitempty"`
+	AutocertDomain *string  `json:"autocert-domain,omitempty"`
+	AutocertCache  *string  `json:"autocert-cache,omitempty"`
+	HTTPRedirect   *int     `json:"http-redirect,omitempty"`
+	FetchTimeout   *string  `json:"fetch-timeout,omitempty"`
+	UserAgent      *string  `json:"user-agent,omitempty"`
+	TCPPorts       *string  `json:"tcp-ports,omitempty"`
+	SkipTCP        *bool    `json:"skip-tcp,omitempty"`
+	AltUDPPorts    *string  `json:"alt-udp-ports,omitempty"`
+	Peer           *string  `json:"peer,omitempty"`
+	ProbeMode      *string  `json:"probe-mode,omitempty"`
+	Bind           *string  `json:"bind,omitempty"`
+	EvictAfter     *string  `json:"evict-after,omitempty"`
+	MaxNodes       *int     `json:"max-nodes,omitempty"`
+	AccessLog      *bool    `json:"access-log,omitempty"`
+	Headless       *bool    `json:"headless,omitempty"`
+	WebhookURL     *string  `json:"webhook-url,omitempty"`
+	Socks5         *string  `json:"socks5,omitempty"`
+	AdminToken     *string  `json:"admin-token,omitempty"`
+	RDNS           *bool    `json:"rdns,omitempty"`
+	Spread         *bool    `json:"spread,omitempty"`
+	EWMA           *float64 `json:"ewma,omitempty"`
+	Jitter         *float64 `json:"jitter,omitempty"`
+}
+
+//reads a json config file, unknown options are rejected so typos don't go unnoticed
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+//sets the flags that weren't given on the command line to the values of the config
+func applyConfig(config *Config) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	//numbers are kept as written, floats would format large ints in exponent notation
+	values := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+
+		if err := flag.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid %s in config: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+//a public key that the node sources list with differing info
+type nodeConflict struct {
+	PublicKey string   `json:"public_key"`
+	Field     string   `json:"field"`
+	Values    []string `json:"values"`
+}
+
+//holds the []nodeConflict found while parsing the most recent node lists
+var conflicts atomic.Value
+
+//returns the info two entries with the same public key disagree on
+//addresses are only compared when both entries have one
+func findConflicts(existing *toxNode, node *toxNode) []nodeConflict {
+	found := []nodeConflict{}
+	check := func(field string, a string, b string) {
+		if !strings.EqualFold(a, b) {
+			found = append(found, nodeConflict{node.PublicKey, field, []string{a, b}})
+		}
+	}
+
+	if isAddressSet(existing.Ipv4Address) && isAddressSet(node.Ipv4Address) {
+		check("ipv4", existing.Ipv4Address, node.Ipv4Address)
+	}
+	if isAddressSet(existing.Ipv6Address) && isAddressSet(node.Ipv6Address) {
+		check("ipv6", existing.Ipv6Address, node.Ipv6Address)
+	}
+	if existing.Maintainer != "" && node.Maintainer != "" {
+		check("maintainer", strings.Join(strings.Fields(existing.Maintainer), " "), strings.Join(strings.Fields(node.Maintainer), " "))
+	}
+	return found
+}
+
+func handleConflictsJSONRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	current, ok := conflicts.Load().([]nodeConflict)
+	if !ok {
+		current = []nodeConflict{}
+	}
+	writeJSON(w, r, current)
+}
@@ -4,7 +4,9 @@ package main
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/GoKillers/libsodium-go/cryptobox"
 )
@@ -12,10 +14,18 @@ import (
 type Crypto struct {
 	PublicKey []byte
 	SecretKey []byte
+
+	//shared keys by the public key they were computed with, the curve operation is the expensive part of a probe
+	sharedKeys      map[string][]byte
+	sharedKeysMutex sync.Mutex
 }
 
 func NewCrypto() (*Crypto, error) {
-	secretKey, publicKey, _ := cryptobox.CryptoBoxKeyPair()
+	secretKey, publicKey, result := generateKeyPair()
+	if result != 0 {
+		return nil, fmt.Errorf("keypair generation failed with code %d", result)
+	}
+
 	return NewCryptoFrom(publicKey, secretKey)
 }
 
@@ -31,7 +41,7 @@ func NewCryptoFrom(publicKey []byte, secretKey []byte) (*Crypto, error) {
 		return nil, fmt.Errorf("secret key must be exactly %d bytes long", secretLen)
 	}
 
-	return &Crypto{publicKey, secretKey}, nil
+	return &Crypto{PublicKey: publicKey, SecretKey: secretKey, sharedKeys: map[string][]byte{}}, nil
 }
 
 func encryptData(data []byte, secretKey []byte, nonce []byte) []byte {
@@ -51,7 +61,13 @@ func encryptData(data []byte, secretKey []byte, nonce []byte) []byte {
 	return encrypted
 }
 
-func decryptData(data []byte, secretKey []byte, nonce []byte) []byte {
+//opens data encrypted with the shared key, which is [mac][ciphertext] like encryptData returns it without padding
+//returns the plaintext, or an error if the mac doesn't match so tampered or foreign packets are rejected
+func decryptData(data []byte, sharedKey []byte, nonce []byte) ([]byte, error) {
+	if len(data) < cryptobox.CryptoBoxMacBytes() {
+		return nil, fmt.Errorf("encrypted data too small: %d bytes", len(data))
+	}
+
 	padding := cryptobox.CryptoBoxBoxZeroBytes()
 
 	tempData := make([]byte, len(data)+padding)
@@ -59,13 +75,23 @@ func decryptData(data []byte, secretKey []byte, nonce []byte) []byte {
 		tempData[i+padding] = data[i]
 	}
 
-	decrypted, result := cryptobox.CryptoBoxOpenAfterNm(tempData, nonce, secretKey)
+	decrypted, result := cryptobox.CryptoBoxOpenAfterNm(tempData, nonce, sharedKey)
 
 	if decrypted == nil || result != 0 {
-		return nil
+		return nil, errors.New("could not decrypt data, the mac doesn't match")
 	}
 
-	return decrypted
+	return decrypted[cryptobox.CryptoBoxZeroBytes():], nil
+}
+
+//increments a nonce in place as a big endian number, like toxcore does between the packets of a tcp session
+func incrementNonce(nonce []byte) {
+	for i := len(nonce) - 1; i >= 0; i-- {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
 }
 
 func nextNonce() []byte {
@@ -78,16 +104,32 @@ func nextBytes(amount int) []byte {
 	return bytes
 }
 
+//the shared key is cached by public key, so a node that changes its key gets a new one
+//returns nil for keys no shared key can be computed with, like the all zero key
 func (c *Crypto) CreateSharedKey(publicKey []byte) []byte {
-	sharedKey, result := cryptobox.CryptoBoxBeforeNm(publicKey, c.SecretKey)
-
-	if result == 0 {
+	c.sharedKeysMutex.Lock()
+	sharedKey, ok := c.sharedKeys[string(publicKey)]
+	c.sharedKeysMutex.Unlock()
+	if ok {
 		return sharedKey
 	}
 
-	return nil
-}
+	//computed without holding the lock so the first scan isn't serialized on it
+	sharedKey, result := cryptobox.CryptoBoxBeforeNm(publicKey, c.SecretKey)
+	if result != 0 {
+		return nil
+	}
+
+	c.sharedKeysMutex.Lock()
+	defer c.sharedKeysMutex.Unlock()
 
-func generateKeyPair() ([]byte, []byte, int) {
-	return cryptobox.CryptoBoxKeyPair()
+	//keys of nodes that left the lists would pile up otherwise
+	if len(c.sharedKeys) >= maxSharedKeys {
+		c.sharedKeys = map[string][]byte{}
+	}
+	c.sharedKeys[string(publicKey)] = sharedKey
+	return sharedKey
 }
+
+//a variable so tests can make keypair generation fail
+var generateKeyPair = cryptobox.CryptoBoxKeyPair
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTCPHandshakeKeyPairFailure(t *testing.T) {
+	generate := generateKeyPair
+	generateKeyPair = func() ([]byte, []byte, int) { return nil, nil, -1 }
+	defer func() { generateKeyPair = generate }()
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	node := &toxNode{PublicKey: strings.Repeat("ab", 32)}
+	result := tryTCPHandshake(node, client, 33445)
+	if result.Error == nil {
+		t.Fatal("expected an error when the temporary keypair can't be generated")
+	}
+}
+
+func TestZeroPublicKey(t *testing.T) {
+	node := &toxNode{PublicKey: strings.Repeat("00", 32)}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	if result := tryTCPHandshake(node, client, 33445); result.Error == nil {
+		t.Error("expected the tcp handshake with the all zero key to fail")
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := getNodes(node, conn, conn.LocalAddr().(*net.UDPAddr)); err == nil {
+		t.Error("expected getnodes with the all zero key to fail")
+	}
+}
+
+//derives the public key the same way crypto_box_keypair does, so the keypair is known up front
+func newKnownCrypto(t *testing.T, seed byte) *Crypto {
+	secretKey := bytes.Repeat([]byte{seed}, 32)
+	key, err := ecdh.X25519().NewPrivateKey(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCryptoFrom(key.PublicKey().Bytes(), secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDecryptDataRoundTrip(t *testing.T) {
+	alice := newKnownCrypto(t, 1)
+	bob := newKnownCrypto(t, 2)
+	nonce := nextNonce()
+	plain := []byte("getnodes request")
+
+	encrypted := encryptData(plain, alice.CreateSharedKey(bob.PublicKey), nonce)
+	if encrypted == nil {
+		t.Fatal("could not encrypt")
+	}
+
+	decrypted, err := decryptData(encrypted[16:], bob.CreateSharedKey(alice.PublicKey), nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plain) {
+		t.Errorf("decrypted %q, expected %q", decrypted, plain)
+	}
+}
+
+func TestDecryptDataTampered(t *testing.T) {
+	alice := newKnownCrypto(t, 1)
+	bob := newKnownCrypto(t, 2)
+	eve := newKnownCrypto(t, 3)
+	nonce := nextNonce()
+	sharedKey := alice.CreateSharedKey(bob.PublicKey)
+	encrypted := encryptData([]byte("getnodes request"), sharedKey, nonce)[16:]
+
+	for i := range encrypted {
+		tampered := append([]byte{}, encrypted...)
+		tampered[i] ^= 1
+		if _, err := decryptData(tampered, sharedKey, nonce); err == nil {
+			t.Fatalf("flipping a bit of byte %d wasn't detected", i)
+		}
+	}
+
+	if _, err := decryptData(encrypted, sharedKey, nextNonce()); err == nil {
+		t.Error("decrypting with the wrong nonce wasn't detected")
+	}
+	if _, err := decryptData(encrypted, eve.CreateSharedKey(bob.PublicKey), nonce); err == nil {
+		t.Error("decrypting with the wrong key wasn't detected")
+	}
+	if _, err := decryptData(encrypted[:10], sharedKey, nonce); err == nil {
+		t.Error("data shorter than a mac wasn't rejected")
+	}
+}
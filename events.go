@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+//every connected /events client has a channel the status updates are sent on
+var (
+	eventClients      = map[chan []byte]struct{}{}
+	eventClientsMutex sync.Mutex
+)
+
+//streams the status as server-sent events, once on connect and then after every scan
+func handleEventsRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	//buffered so a client that hasn't caught up yet only ever gets the latest status
+	updates := make(chan []byte, 1)
+	eventClientsMutex.Lock()
+	eventClients[updates] = struct{}{}
+	eventClientsMutex.Unlock()
+
+	defer func() {
+		eventClientsMutex.Lock()
+		delete(eventClients, updates)
+		eventClientsMutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	data, err := json.Marshal(getStatus())
+	if err != nil {
+		return
+	}
+
+	for {
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+
+		select {
+		case data = <-updates:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+//sends the current status to every connected /events client
+func broadcastStatus() {
+	eventClientsMutex.Lock()
+	defer eventClientsMutex.Unlock()
+
+	if len(eventClients) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(getStatus())
+	if err != nil {
+		slog.Warn("error encoding status event", "error", err)
+		return
+	}
+
+	for updates := range eventClients {
+		//replace an update the client hasn't read yet
+		select {
+		case <-updates:
+		default:
+		}
+		updates <- data
+	}
+}
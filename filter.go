@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//public keys are stored in lower case like the keys of the nodes, a nil set means no filtering
+var (
+	includedKeys map[string]bool
+	excludedKeys map[string]bool
+)
+
+//parses a comma separated list of public keys, or a file containing one
+func parseKeyList(value string) (map[string]bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		content, err := ioutil.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+		value = string(content)
+	}
+
+	//a mistyped file name would otherwise filter out every node
+	keys := map[string]bool{}
+	for _, entry := range strings.FieldsFunc(value, isKeyListSeparator) {
+		key, err := normalizePublicKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither a public key nor a file: %s", entry, err)
+		}
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+func isKeyListSeparator(r rune) bool {
+	return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+}
+
+//returns the nodes whose keys pass the filters, see isKeyAllowed
+func filterAllowedNodes(nodes []*toxNode) []*toxNode {
+	allowed := []*toxNode{}
+	for _, node := range nodes {
+		if isKeyAllowed(node.PublicKey) {
+			allowed = append(allowed, node)
+		}
+	}
+	return allowed
+}
+
+//the exclude list wins if a key is on both lists
+func isKeyAllowed(publicKey string) bool {
+	publicKey = strings.ToLower(publicKey)
+	if excludedKeys[publicKey] {
+		return false
+	}
+
+	return includedKeys == nil || includedKeys[publicKey]
+}
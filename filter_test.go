@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyList(t *testing.T) {
+	key := strings.Repeat("0123456789abcdef", 4)
+	other := strings.Repeat("fedcba9876543210", 4)
+
+	keys, err := parseKeyList(strings.ToUpper(key) + ", " + other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !keys[key] || !keys[other] {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	os.WriteFile(path, []byte(key+"\n"+other+"\n"), 0600)
+	if keys, err = parseKeyList(path); err != nil || len(keys) != 2 {
+		t.Errorf("expected the 2 keys of the file, got %v and %v", keys, err)
+	}
+
+	for _, value := range []string{"keys.txt", key + ",abc", key[:63]} {
+		if _, err := parseKeyList(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
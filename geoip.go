@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+type geoIPLocation struct {
+	CountryCode string
+	Country     string
+	City        string
+}
+
+var (
+	geoIPReader *geoip2.Reader
+	geoIPCache  = map[string]*geoIPLocation{}
+	geoIPMutex  sync.Mutex
+)
+
+func loadGeoIP(path string) error {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return err
+	}
+
+	geoIPReader = reader
+	return nil
+}
+
+//overrides the location of the node with the one from the geoip database, if any
+func locateNode(node *toxNode) {
+	if geoIPReader == nil {
+		return
+	}
+
+	address := node.Ipv4Address
+	if net.ParseIP(address) == nil {
+		address = node.Ipv6Address
+	}
+
+	location := lookupLocation(address)
+	if location == nil || location.CountryCode == "" {
+		return
+	}
+
+	node.Location = location.CountryCode
+	node.LocationFull = countries[location.CountryCode]
+	if node.LocationFull == "" {
+		node.LocationFull = location.Country
+	}
+	if location.City != "" {
+		node.LocationFull = fmt.Sprintf("%s, %s", location.City, node.LocationFull)
+	}
+}
+
+//results are cached by ip, including failed lookups
+func lookupLocation(address string) *geoIPLocation {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil
+	}
+
+	geoIPMutex.Lock()
+	defer geoIPMutex.Unlock()
+
+	if location, ok := geoIPCache[address]; ok {
+		return location
+	}
+
+	var location *geoIPLocation
+	if record, err := geoIPReader.City(ip); err == nil {
+		location = &geoIPLocation{
+			record.Country.IsoCode,
+			record.Country.Names["en"],
+			record.City.Names["en"],
+		}
+	}
+
+	geoIPCache[address] = location
+	return location
+}
@@ -2,22 +2,28 @@ package main
 
 import (
 	"bytes"
-	"container/list"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/GoKillers/libsodium-go/cryptobox"
@@ -27,28 +33,67 @@ const (
 	httpListenPort                   = 8081
 	refreshRate                      = 60 //in seconds
 	wikiURI                          = "https://wiki.tox.chat/users/nodes?do=export_raw"
+	jsonURI                          = "https://nodes.tox.chat/json"
+	snapshotPath                     = "./snapshot.json"
+	sourceCachePath                  = "./sources_cache.json"
+	maxSourceSize                    = 4 << 20 //in bytes
 	maxUDPPacketSize                 = 2048
+	maxGetNodesReads                 = 8
 	getNodesPacketID                 = 2
 	sendNodesIpv6PacketID            = 4
 	bootstrapInfoPacketID            = 240
+	packedNodeIPv4Family             = 2
+	packedNodeIPv6Family             = 10
 	bootstrapInfoPacketLength        = 78
 	tcpHandshakePacketLength         = 128
 	tcpHandshakeResponsePacketLength = 96
+	tcpPingPacketID                  = 4
+	tcpPongPacketID                  = 5
+	tcpPingIDLength                  = 8
+	maxTCPPacketSize                 = 2048
+	maxTCPRelayReads                 = 8
 	maxMOTDLength                    = 256
-	queryTimeout                     = 4 //in seconds
-	dialerTimeout                    = 4 //in seconds
+	maxMOTDDisplayLength             = 160 //in runes
+	queryTimeout                     = 4   //in seconds
+	dialerTimeout                    = 4   //in seconds
+	probeWorkers                     = 16
+	maxDials                         = 64
+	maxNodes                         = 10000
+	maxSharedKeys                    = 2 * maxNodes
+	probeAttempts                    = 3
+	scanTimeout                      = 5 * time.Minute
+	sourceFetchTimeout               = 30 * time.Second
+	httpRateBurst                    = 100
+	probeBackoff                     = 500 * time.Millisecond
+	latencyEWMAAlpha                 = 0.3
+	probeJitter                      = 0.1
+	assetsMaxAge                     = 3600     //in seconds
+	versionedAssetsMaxAge            = 31536000 //in seconds, a year
 )
 
 var (
-	lastScan  int64
-	nodesList = list.New()
+	nodesList []*toxNode   //only touched by probeLoop once it's running
+	status    atomic.Value //holds the toxStatus that is currently being served
 	crypto, _ = NewCrypto()
-	tcpPorts  = []int{443, 3389, 33445}
+	tcpPorts  = []int{443, 3389, 33445} //probed on every node on top of its own ports, see -tcp-ports
 	funcMap   = template.FuncMap{
-		"lower": strings.ToLower,
-		"inc":   increment,
+		"lower":    strings.ToLower,
+		"inc":      increment,
+		"contains": contains,
+		"date":     formatDate,
+		"assetURL": assetURL,
+		"isUp":     isNodeUp,
 	}
-	countries map[string]string
+	countries            map[string]string
+	templates            = map[string]*template.Template{}
+	templatesMutex       sync.Mutex
+	healthyResponse      = []byte(`{"status":"ok"}`)
+	unhealthyResponse    = []byte(`{"status":"waiting for the first scan"}`)
+	dialSlots            chan struct{}
+	nodeSources          []NodeSource
+	altUDPPorts          []int //tried when none of the listed udp ports answer, see -alt-udp-ports
+	jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+	now                  = time.Now //the clock timestamps are taken from, latencies and deadlines use the real one
 )
 
 //flags
@@ -59,56 +104,268 @@ var (
 	keyFlag     = flag.String("key", "", "public key of the node")
 )
 
+//server flags
+var (
+	configFlag         = flag.String("config", "", "path to a json config file with options named after the flags, flags given on the command line win")
+	httpPortFlag       = flag.Int("http-port", httpListenPort, "port to serve the status page on")
+	refreshFlag        = flag.Duration("refresh", refreshRate*time.Second, "time between two scans")
+	udpTimeoutFlag     = flag.Duration("udp-timeout", queryTimeout*time.Second, "time to wait for a node to respond over udp")
+	tcpTimeoutFlag     = flag.Duration("tcp-timeout", queryTimeout*time.Second, "time to wait for a node to respond to a tcp handshake")
+	dialTimeoutFlag    = flag.Duration("dial-timeout", dialerTimeout*time.Second, "time to wait for a connection to a node")
+	wikiURLFlag        = flag.String("wiki-url", wikiURI, "url of the wiki node list")
+	jsonURLFlag        = flag.String("json-url", jsonURI, "url of the json node list")
+	sourcesFlag        = flag.String("sources", "wiki", "comma separated list of node sources to use, either 'wiki' or 'json'")
+	assetsDirFlag      = flag.String("assets-dir", "", "directory to read the status page assets from instead of the embedded ones, useful while editing them")
+	includeFlag        = flag.String("include", "", "comma separated public keys, or a file containing them, to limit probing to")
+	excludeFlag        = flag.String("exclude", "", "comma separated public keys, or a file containing them, to never probe, wins over -include")
+	scanTimeoutFlag    = flag.Duration("scan-timeout", scanTimeout, "maximum duration of a scan, including fetching the node sources")
+	workersFlag        = flag.Int("workers", probeWorkers, "maximum number of nodes to probe at once")
+	maxDialsFlag       = flag.Int("max-dials", maxDials, "maximum number of tcp handshakes in flight at once")
+	rateLimitFlag      = flag.Float64("rate-limit", 0, "requests per second allowed per client ip, 0 disables rate limiting, leave it off behind a reverse proxy as every client shares its ip")
+	rateBurstFlag      = flag.Int("rate-burst", httpRateBurst, "requests a client ip may make in a burst")
+	attemptsFlag       = flag.Int("attempts", probeAttempts, "number of getnodes queries to send before a node is considered down")
+	corsOriginFlag     = flag.String("cors-origin", "*", "value of the Access-Control-Allow-Origin header of the json api, empty disables cors")
+	devFlag            = flag.Bool("dev", false, "parse templates on every request, useful while editing them")
+	logLevelFlag       = flag.String("log-level", "info", "minimum level of log messages, either 'debug', 'info', 'warn' or 'error'")
+	dbFlag             = flag.String("db", "", "path to a sqlite database to record the result of every scan in")
+	geoIPFlag          = flag.String("geoip-db", "", "path to a maxmind geolite2 city database used to locate nodes")
+	tlsCertFlag        = flag.String("tls-cert", "", "path to a tls certificate, serves the status page over https together with -tls-key")
+	tlsKeyFlag         = flag.String("tls-key", "", "path to the private key of the tls certificate")
+	autocertDomainFlag = flag.String("autocert-domain", "", "comma separated domains to fetch let's encrypt certificates for, serves the status page over https")
+	autocertCacheFlag  = flag.String("autocert-cache", "./certs", "directory to cache let's encrypt certificates in")
+	httpRedirectFlag   = flag.Int("http-redirect", 0, "port to redirect plain http requests to https from, 0 disables it")
+	fetchTimeoutFlag   = flag.Duration("fetch-timeout", sourceFetchTimeout, "maximum duration of fetching a node source")
+	userAgentFlag      = flag.String("user-agent", "ToxStatus/"+Version, "user agent sent when fetching the node sources")
+	tcpPortsFlag       = flag.String("tcp-ports", "443,3389,33445", "comma separated tcp ports to probe on every node, besides its own port and the ones it advertises")
+	skipTCPFlag        = flag.Bool("skip-tcp", false, "don't probe tcp at all, same as -probe-mode udp")
+	altUDPPortsFlag    = flag.String("alt-udp-ports", "", "comma separated udp ports to try when a node doesn't answer on the ones it lists, empty disables it as it multiplies the probes of offline nodes")
+	peerFlag           = flag.String("peer", "", "comma separated /json urls of other toxstatus instances to merge the results of on /json/global")
+	probeModeFlag      = flag.String("probe-mode", "both", "transports to probe the nodes over, either 'udp', 'tcp' or 'both'")
+	bindFlag           = flag.String("bind", "", "address to serve the status page on, all interfaces if empty, a port in it replaces -http-port")
+	evictAfterFlag     = flag.Duration("evict-after", time.Hour, "time to keep probing nodes that were removed from the node sources")
+	maxNodesFlag       = flag.Int("max-nodes", maxNodes, "maximum number of nodes to probe, the rest of the node sources is ignored")
+	accessLogFlag      = flag.Bool("access-log", false, "log every http request")
+	headlessFlag       = flag.Bool("headless", false, "only serve the json api, the status page and its assets are disabled")
+	webhookURLFlag     = flag.String("webhook-url", "", "url to post the nodes that went up or down to after every scan")
+	socks5Flag         = flag.String("socks5", "", "address of a socks5 proxy, like tor, to make tcp connections to nodes through, implies -probe-mode tcp")
+	adminTokenFlag     = flag.String("admin-token", "", "bearer token required by POST /rescan, empty disables the endpoint")
+	rdnsFlag           = flag.Bool("rdns", false, "look up the hostnames of the nodes, cached for the lifetime of the process")
+	spreadFlag         = flag.Bool("spread", false, "pace the probes over the first half of the refresh interval instead of starting them all at once")
+	ewmaFlag           = flag.Float64("ewma", latencyEWMAAlpha, "smoothing factor of the average latency, higher values favor recent scans")
+	jitterFlag         = flag.Float64("jitter", probeJitter, "fraction of the probe timeouts and retry backoffs to randomly add or take off, so they don't fire all at once")
+)
+
 type tcpHandshakeResult struct {
-	Port  int
-	Error error
+	Port     int
+	Error    error
+	Verified bool //the relay answered a ping over the established session
 }
 
 type toxStatus struct {
-	LastScan       int64     `json:"last_scan"`
-	LastScanString string    `json:"last_scan_string"`
-	Nodes          []toxNode `json:"nodes"`
+	LastScan        int64     `json:"last_scan"`
+	LastScanString  string    `json:"last_scan_string"`
+	LastSourceFetch int64     `json:"last_source_fetch"`
+	ScanDurationMS  int64     `json:"scan_duration_ms"`
+	ScanInProgress  bool      `json:"scan_in_progress"` //the nodes are still those of the previous scan
+	NodesTotal      int       `json:"nodes_total"`
+	NodesOnline     int       `json:"nodes_online"`
+	Nodes           []toxNode `json:"nodes"`
 }
 
 type toxNode struct {
-	Ipv4Address    string `json:"ipv4"`
-	Ipv6Address    string `json:"ipv6"`
-	Port           int    `json:"port"`
-	TCPPorts       []int  `json:"tcp_ports"`
-	PublicKey      string `json:"public_key"`
-	Maintainer     string `json:"maintainer"`
-	Location       string `json:"location"`
-	LocationFull   string `json:"location_full"`
-	UDPStatus      bool   `json:"status_udp"`
-	TCPStatus      bool   `json:"status_tcp"`
-	Version        string `json:"version"`
-	MOTD           string `json:"motd"`
-	LastPing       int64  `json:"last_ping"`
-	LastPingString string `json:"last_ping_string"`
+	Ipv4Address        string    `json:"ipv4"`
+	Ipv6Address        string    `json:"ipv6"`
+	Port               int       `json:"port"`      //the first of UDPPorts
+	UDPPorts           []int     `json:"udp_ports"` //as listed by the source
+	OpenUDPPorts       []int     `json:"udp_ports_open"`
+	TCPPorts           []int     `json:"tcp_ports"`
+	AdvertisedTCPPorts []int     `json:"tcp_ports_advertised"`
+	PublicKey          string    `json:"public_key"`
+	Maintainer         string    `json:"maintainer"`
+	Location           string    `json:"location"`
+	LocationFull       string    `json:"location_full"`
+	Hostname           string    `json:"hostname"`
+	UDPStatus          bool      `json:"status_udp"`
+	TCPStatus          bool      `json:"status_tcp"`
+	TCPRelayVerified   bool      `json:"tcp_relay_verified"` //the relay also answered a ping after the handshake
+	UDPStatusIpv4      bool      `json:"status_udp_ipv4"`
+	UDPStatusIpv6      bool      `json:"status_udp_ipv6"`
+	TCPStatusIpv4      bool      `json:"status_tcp_ipv4"`
+	TCPStatusIpv6      bool      `json:"status_tcp_ipv6"`
+	HasBootstrapInfo   bool      `json:"has_bootstrap_info"` //false means Version, MOTD and RawInfo are unknown rather than empty
+	Version            string    `json:"version"`
+	VersionRaw         uint32    `json:"version_raw"`
+	MOTD               string    `json:"motd"`
+	RawInfo            string    `json:"raw_info"` //hex of whatever followed the motd
+	LastPing           int64     `json:"last_ping"`
+	LastPingString     string    `json:"last_ping_string"`
+	FirstSeen          int64     `json:"first_seen"`
+	RemovedFromSource  int64     `json:"removed_from_source"` //when the node left the sources, 0 while it's listed
+	LatencyMS          int64     `json:"latency_ms"`
+	BootstrapInfoRTTMS int64     `json:"bootstrap_info_rtt_ms"` //the response carries no timestamp, so clock skew can't be measured
+	LatencyAvgMS       float64   `json:"latency_avg_ms"`
+	LatencyAvgStale    bool      `json:"latency_avg_stale"`
+	WorkingUDPPort     int       `json:"udp_port_working"`  //the first port the node answered on, 0 if none did
+	UDPPortMismatch    bool      `json:"udp_port_mismatch"` //the node only answered on one of -alt-udp-ports, its listing is out of date
+	AddressMismatch    bool      `json:"address_mismatch"`
+	ObservedAddress    string    `json:"observed_address"`
+	LastError          string    `json:"last_error"`
+	StreakUp           int       `json:"streak_up"`   //consecutive scans the node was up
+	StreakDown         int       `json:"streak_down"` //consecutive scans the node was down
+	UptimePercent      float64   `json:"uptime"`
+	DiscoveredNodes    []dhtNode `json:"discovered_nodes"`
+	NeighborCount      int       `json:"neighbor_count"` //a healthy dht node returns 4
+	IsRelay            bool      `json:"is_relay"`       //at least one tcp handshake succeeded, so clients can use it as a tcp relay
+	DHTOnly            bool      `json:"dht_only"`       //answered over udp but accepted no tcp handshake
+
+	history               *uptimeHistory
+	bootstrapInfoTimedOut bool //the node answered getnodes but ignored a bootstrap info request on the same port during this scan
+}
+
+//a node as packed in a sendnodesipv6 response
+type dhtNode struct {
+	Address   string `json:"address"`
+	Port      int    `json:"port"`
+	PublicKey string `json:"public_key"`
 }
 
 func main() {
+	flag.Parse()
+	if *configFlag != "" {
+		config, err := loadConfig(*configFlag)
+		if err == nil {
+			err = applyConfig(config)
+		}
+		if err != nil {
+			fatal("invalid config", "error", err)
+		}
+	}
+
+	if err := setupLogger(*logLevelFlag); err != nil {
+		fatal("invalid flags", "error", err)
+	}
+
 	if crypto == nil {
-		log.Fatalf("Could not generate keypair")
+		fatal("could not generate keypair")
+	}
+
+	if err := validateFlags(); err != nil {
+		fatal("invalid flags", "error", err)
+	}
+	dialSlots = make(chan struct{}, *maxDialsFlag)
+	sourceClient = &http.Client{Timeout: *fetchTimeoutFlag}
+	if *tcpPortsFlag != "" {
+		tcpPorts = parsePorts(*tcpPortsFlag)
+	}
+	altUDPPorts = parsePorts(*altUDPPortsFlag)
+	peerURLs = parsePeerURLs(*peerFlag)
+
+	if *socks5Flag != "" {
+		if err := loadProxy(*socks5Flag); err != nil {
+			fatal("error setting up the socks5 proxy", "error", err)
+		}
 	}
 
 	if handleFlags() {
 		return
 	}
 
-	if err := loadCountries(); err != nil {
-		log.Fatalf("error loading countries.json: %s", err)
+	var err error
+	if assets, err = loadAssets(); err != nil {
+		fatal("error loading assets", "error", err)
+	}
+
+	if !*headlessFlag {
+		if err := hashAssets(); err != nil {
+			fatal("error hashing assets", "error", err)
+		}
+	}
+
+	//country names are only nice to have in the json, so headless mode goes on without them
+	if err := loadCountries(); err != nil && *headlessFlag {
+		slog.Warn("error loading countries.json", "error", err)
+	} else if err != nil {
+		fatal("error loading countries.json", "error", err)
+	}
+
+	sources, err := parseNodeSources(*sourcesFlag)
+	if err != nil {
+		fatal("invalid flags", "error", err)
+	}
+	nodeSources = sources
+
+	if includedKeys, err = parseKeyList(*includeFlag); err != nil {
+		fatal("error loading the public keys to include", "error", err)
+	}
+	if excludedKeys, err = parseKeyList(*excludeFlag); err != nil {
+		fatal("error loading the public keys to exclude", "error", err)
+	}
+
+	if *geoIPFlag != "" {
+		if err := loadGeoIP(*geoIPFlag); err != nil {
+			fatal("error loading geoip database", "error", err)
+		}
+	}
+
+	if *dbFlag != "" {
+		if err := openHistoryDB(*dbFlag); err != nil {
+			fatal("error opening history database", "error", err)
+		}
+	}
+
+	//the snapshot is saved after every scan so its mtime is when the last one finished
+	if nodes, lastScan, err := loadCachedNodes(snapshotPath); err == nil {
+		nodes = filterAllowedNodes(nodes) //the filters may have changed since the snapshot was taken
+		nodesList = nodes
+		publishStatus(nodes, lastScan, 0, 0)
+	} else if !os.IsNotExist(err) {
+		slog.Warn("error loading node snapshot", "error", err)
 	}
 
 	go probeLoop()
 
-	http.HandleFunc("/", handleHTTPRequest)
+	if !*headlessFlag {
+		http.HandleFunc("/", handleHTTPRequest)
+	}
 	http.HandleFunc("/json", handleJSONRequest)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", httpListenPort), nil))
+	http.HandleFunc("/json/online", handleOnlineJSONRequest)
+	http.HandleFunc("/json/by-maintainer", handleMaintainersJSONRequest)
+	http.HandleFunc("/json/by-country", handleCountriesJSONRequest)
+	http.HandleFunc("/json/node/", handleNodeJSONRequest)
+	http.HandleFunc("/json/summary", handleSummaryJSONRequest)
+	http.HandleFunc("/json/conflicts", handleConflictsJSONRequest)
+	http.HandleFunc("/events", handleEventsRequest)
+	http.HandleFunc("/badge", handleBadgeRequest)
+	http.HandleFunc("/nodes.json", handleNodesJSONRequest)
+	http.HandleFunc("/txt", handleTextRequest)
+	http.HandleFunc("/csv", handleCSVRequest)
+	http.HandleFunc("/keys", handleKeysRequest)
+	if historyDB != nil {
+		http.HandleFunc("/history", handleHistoryRequest)
+	}
+	if len(peerURLs) > 0 {
+		http.HandleFunc("/json/global", handleGlobalJSONRequest)
+	}
+	http.HandleFunc("/metrics", handleMetricsRequest)
+	http.HandleFunc("/health", handleHealthRequest)
+	http.HandleFunc("/version", handleVersionRequest)
+	if *adminTokenFlag != "" {
+		http.HandleFunc("/rescan", handleRescanRequest)
+	}
+	var handler http.Handler = http.DefaultServeMux
+	if *rateLimitFlag > 0 {
+		handler = newRateLimiter(*rateLimitFlag, *rateBurstFlag).limit(handler)
+	}
+	if *accessLogFlag {
+		handler = logRequests(handler)
+	}
+
+	err = listenAndServe(handler)
+	fatal("http server stopped", "error", err)
 }
 
 func loadCountries() error {
-	bytes, err := ioutil.ReadFile("./assets/countries.json")
+	bytes, err := fs.ReadFile(assets, "countries.json")
 	if err != nil {
 		return err
 	}
@@ -117,273 +374,1080 @@ func loadCountries() error {
 	return err
 }
 
+func validateFlags() error {
+	if err := parseBindFlag(); err != nil {
+		return err
+	}
+
+	if *httpPortFlag < 1 || *httpPortFlag > 65535 {
+		return fmt.Errorf("invalid http port: %d", *httpPortFlag)
+	} else if *refreshFlag <= 0 {
+		return errors.New("refresh rate must be positive")
+	} else if *udpTimeoutFlag <= 0 {
+		return errors.New("udp timeout must be positive")
+	} else if *tcpTimeoutFlag <= 0 {
+		return errors.New("tcp timeout must be positive")
+	} else if *dialTimeoutFlag <= 0 {
+		return errors.New("dial timeout must be positive")
+	} else if *scanTimeoutFlag <= 0 {
+		return errors.New("scan timeout must be positive")
+	} else if *fetchTimeoutFlag <= 0 {
+		return errors.New("fetch timeout must be positive")
+	} else if *workersFlag < 1 {
+		return errors.New("there must be at least one worker")
+	} else if *maxDialsFlag < 1 {
+		return errors.New("there must be at least one dial slot")
+	} else if *evictAfterFlag < 0 {
+		return errors.New("evict after can't be negative")
+	} else if *maxNodesFlag < 1 {
+		return errors.New("there must be room for at least one node")
+	} else if *attemptsFlag < 1 {
+		return errors.New("there must be at least one probe attempt")
+	} else if *rateLimitFlag < 0 {
+		return errors.New("rate limit can't be negative")
+	} else if *rateBurstFlag < 1 {
+		return errors.New("rate burst must be at least 1")
+	} else if *ewmaFlag <= 0 || *ewmaFlag > 1 {
+		return errors.New("ewma smoothing factor must be in (0, 1]")
+	} else if *jitterFlag < 0 || *jitterFlag >= 1 {
+		return errors.New("jitter must be in [0, 1)")
+	} else if err := validatePorts(*tcpPortsFlag); *tcpPortsFlag != "" && err != nil {
+		return err
+	} else if err := validatePorts(*altUDPPortsFlag); *altUDPPortsFlag != "" && err != nil {
+		return err
+	} else if *spreadFlag && *scanTimeoutFlag <= *refreshFlag/2 {
+		return errors.New("scan timeout must be longer than half the refresh rate when spreading probes")
+	} else if *probeModeFlag != "udp" && *probeModeFlag != "tcp" && *probeModeFlag != "both" {
+		return fmt.Errorf("unknown probe mode: %s", *probeModeFlag)
+	} else if *skipTCPFlag && *probeModeFlag == "tcp" {
+		return errors.New("-skip-tcp can't be combined with -probe-mode tcp")
+	}
+
+	if *skipTCPFlag {
+		*probeModeFlag = "udp"
+	}
+
+	//socks5 proxies don't relay udp, so only tcp can be probed through one
+	if *socks5Flag != "" {
+		if *probeModeFlag == "udp" {
+			return errors.New("-socks5 can't be combined with -probe-mode udp")
+		}
+		*probeModeFlag = "tcp"
+	}
+
+	return validateTLSFlags()
+}
+
+//returns true if we were asked to probe a single node instead of running the status page
 func handleFlags() bool {
-	if len(os.Args) < 2 {
+	if *keyFlag == "" {
 		return false
 	}
 
-	flag.Parse()
-
 	if len(*keyFlag) != 64 {
-		log.Fatalln("error: public key must have a lenght of 64 hex characters")
+		fatal("public key must have a length of 64 hex characters")
 	}
 
-	node := toxNode{}
-	node.Ipv4Address = *ipFlag //HACK: ipv6 addresses will also end up here
+	node := toxNode{LatencyMS: -1, BootstrapInfoRTTMS: -1}
+	if ip := net.ParseIP(*ipFlag); ip != nil && ip.To4() == nil {
+		node.Ipv6Address = *ipFlag
+	} else {
+		node.Ipv4Address = *ipFlag
+	}
 	node.PublicKey = *keyFlag
 	node.Port = *portFlag
 
 	if *networkFlag == "udp" {
 		err := probeNode(&node)
 		if err == nil {
-			log.Println("success: this node appears to be online!")
+			slog.Info("success: this node appears to be online!", "latency_ms", node.LatencyMS)
 		} else {
-			log.Printf("error: %s", err.Error())
-			log.Println("fail: this node appears to be offline!")
+			slog.Error("fail: this node appears to be offline!", "error", err)
 		}
 	} else if *networkFlag == "tcp" {
 		err := probeNodeTCP(&node)
 		if err == nil {
-			log.Println("success: this relay appears to be online!")
+			slog.Info("success: this relay appears to be online!", "relay_verified", node.TCPRelayVerified)
 		} else {
-			log.Printf("error: %s", err.Error())
-			log.Println("fail: this relay appears to be offline!")
+			slog.Error("fail: this relay appears to be offline!", "error", err)
 		}
 	} else {
-		log.Fatalf("error: unsupported network specified: %s", *networkFlag)
+		fatal("unsupported network specified", "network", *networkFlag)
 	}
 
 	return true
 }
 
 func handleHTTPRequest(w http.ResponseWriter, r *http.Request) {
-	urlPath := r.URL.Path[1:]
 	if r.URL.Path == "/" {
-		renderMainPage(w, "index.html")
+		renderMainPage(w, r, "index.html")
 		return
 	}
 
-	//TODO: make this more efficient
-	data, err := ioutil.ReadFile(path.Join("./assets/", string(urlPath)))
+	//cleaning against the root keeps the name inside the assets, fs.FS rejects anything else
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	file, err := assets.Open(name)
 	if err != nil {
 		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	content, seekable := file.(io.ReadSeeker)
+	if err != nil || info.IsDir() || !seekable {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	//ServeContent takes care of Last-Modified, If-Modified-Since and the Content-Type
+	modTime := info.ModTime()
+	if modTime.IsZero() {
+		modTime = assetsModTime
+	}
+
+	//urls from assetURL change with the content, so those can be cached for good
+	if r.URL.Query().Get("v") != "" {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", versionedAssetsMaxAge))
 	} else {
-		w.Write(data)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", assetsMaxAge))
 	}
+	http.ServeContent(w, r, info.Name(), modTime, content)
 }
 
-func renderMainPage(w http.ResponseWriter, urlPath string) {
-	tmpl, err := template.New("index.html").
-		Funcs(funcMap).
-		ParseFiles(path.Join("./assets/", string(urlPath)))
+func renderMainPage(w http.ResponseWriter, r *http.Request, urlPath string) {
+	render := func(current toxStatus) ([]byte, error) {
+		tmpl, err := getTemplate(urlPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("status page template not found, serving the built-in page, run with -headless or set -assets-dir", "template", urlPath)
+			tmpl = fallbackTemplate
+		} else if err != nil {
+			return nil, err
+		}
+
+		var buffer bytes.Buffer
+		err = tmpl.Execute(&buffer, current)
+		return buffer.Bytes(), err
+	}
+
+	//templates are edited live in dev mode, so don't cache what they render
+	var data []byte
+	var err error
+	if *devFlag {
+		data, err = render(getStatus())
+	} else {
+		data, err = getCachedResponse("page:"+urlPath, render)
+	}
 
 	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
-		log.Printf("Internal server error while trying to serve index: %s", err.Error())
-	} else {
-		nodes := nodesListToSlice(nodesList)
-		response := toxStatus{lastScan, time.Unix(lastScan, 0).String(), nodes}
-		tmpl.Execute(w, response)
+		slog.Error("internal server error while trying to serve index", "error", err)
+		return
+	}
+
+	writeWithETag(w, r, data, "text/html; charset=utf-8")
+}
+
+//templates are parsed once, unless we're in dev mode
+func getTemplate(urlPath string) (*template.Template, error) {
+	if *devFlag {
+		return parseTemplate(urlPath)
+	}
+
+	templatesMutex.Lock()
+	defer templatesMutex.Unlock()
+
+	if tmpl, ok := templates[urlPath]; ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := parseTemplate(urlPath)
+	if err != nil {
+		return nil, err
 	}
+
+	templates[urlPath] = tmpl
+	return tmpl, nil
+}
+
+func parseTemplate(urlPath string) (*template.Template, error) {
+	return template.New(path.Base(urlPath)).
+		Funcs(funcMap).
+		ParseFS(assets, urlPath)
 }
 
 func handleJSONRequest(w http.ResponseWriter, r *http.Request) {
-	nodes := nodesListToSlice(nodesList)
-	response := toxStatus{lastScan, time.Unix(lastScan, 0).String(), nodes}
+	if handleCORS(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	online := query.Get("status") == "online"
+	paged := query.Get("limit") != "" || query.Get("offset") != "" || query.Get("sort") != ""
+	callback := query.Get("callback")
+	if paged || callback != "" {
+		response := getStatus()
+		if online {
+			response.Nodes = filterOnlineNodes(response.Nodes)
+		}
 
-	bytes, err := json.Marshal(response)
+		var value interface{} = response
+		if paged {
+			page, err := paginateStatus(response, query)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			value = page
+		}
+
+		if callback != "" {
+			writeJSONP(w, r, callback, value)
+		} else {
+			writeJSON(w, r, value)
+		}
+		return
+	}
+
+	if online {
+		writeCachedJSON(w, r, "json:online", onlineStatus)
+	} else {
+		writeCachedJSON(w, r, "json", func(current toxStatus) interface{} { return current })
+	}
+}
+
+func handleOnlineJSONRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	writeCachedJSON(w, r, "json:online", onlineStatus)
+}
+
+func onlineStatus(current toxStatus) interface{} {
+	current.Nodes = filterOnlineNodes(current.Nodes)
+	return current
+}
+
+//returns the nodes that are up, see isNodeUp
+func filterOnlineNodes(nodes []toxNode) []toxNode {
+	online := []toxNode{}
+	for _, node := range nodes {
+		if isNodeUp(node) {
+			online = append(online, node)
+		}
+	}
+	return online
+}
+
+//serializes the value and writes it with an etag, compressing it if possible
+func writeJSON(w http.ResponseWriter, r *http.Request, value interface{}) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	writeWithETag(w, r, bytes, "application/json; charset=utf-8")
+}
+
+//wraps the json in a call to callback, which must be a valid identifier
+func writeJSONP(w http.ResponseWriter, r *http.Request, callback string, value interface{}) {
+	if !jsonpCallbackPattern.MatchString(callback) {
+		http.Error(w, "invalid callback name", http.StatusBadRequest)
+		return
+	}
+
+	bytes, err := json.Marshal(value)
 	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
 
-	w.Write(bytes)
+	//nosniff and the leading comment keep the response from being read as anything but a script
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeWithETag(w, r, []byte(fmt.Sprintf("/**/%s(%s);", callback, bytes)), "application/javascript; charset=utf-8")
+}
+
+func writeWithETag(w http.ResponseWriter, r *http.Request, data []byte, contentType string) {
+	//a strong etag has to differ between the gzipped and the identity response
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(data))
+	if acceptsGzip(r) {
+		etag = fmt.Sprintf(`"%x-gzip"`, sha1.Sum(data))
+	}
+	w.Header().Set("ETag", etag)
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	writeCompressed(w, r, data)
+}
+
+//sets the cors headers of a public endpoint
+//returns true if the request was a preflight request that has been answered
+func handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	if *corsOriginFlag == "" {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", *corsOriginFlag)
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+	if *corsOriginFlag != "*" {
+		w.Header().Add("Vary", "Origin")
+	}
+
+	if r.Method != http.MethodOptions {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "If-None-Match")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+//reports whether at least one scan has completed
+func handleHealthRequest(w http.ResponseWriter, r *http.Request) {
+	current, _ := status.Load().(toxStatus)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if current.LastScan == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(unhealthyResponse)
+		return
+	}
+
+	w.Write(healthyResponse)
+}
+
+//gzips the response if the client supports it
+func writeCompressed(w http.ResponseWriter, r *http.Request, data []byte) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	writer := gzip.NewWriter(w)
+	writer.Write(data)
+	writer.Close()
+}
+
+func acceptsGzip(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+}
+
+//replaces the served status with an immutable copy of the given nodes
+func publishStatus(nodes []*toxNode, lastScan int64, lastSourceFetch int64, scanDuration time.Duration) {
+	nodesSlice := copyNodes(nodes)
+
+	online := 0
+	for _, node := range nodesSlice {
+		if isNodeUp(node) {
+			online++
+		}
+	}
+
+	status.Store(toxStatus{
+		LastScan:        lastScan,
+		LastScanString:  time.Unix(lastScan, 0).String(),
+		LastSourceFetch: lastSourceFetch,
+		ScanDurationMS:  scanDuration.Nanoseconds() / int64(time.Millisecond),
+		NodesTotal:      len(nodesSlice),
+		NodesOnline:     online,
+		Nodes:           nodesSlice,
+	})
+}
+
+//republishes the served status with ScanInProgress changed, publishStatus clears it too
+func setScanInProgress(inProgress bool) {
+	current, _ := status.Load().(toxStatus)
+	current.ScanInProgress = inProgress
+	status.Store(current)
+}
+
+//returns a copy of the served status that is safe to modify
+func getStatus() toxStatus {
+	current, _ := status.Load().(toxStatus)
+
+	nodes := make([]toxNode, len(current.Nodes))
+	copy(nodes, current.Nodes)
+	for i := range nodes {
+		//while we're at it, let's update the last ping string!
+		if nodes[i].LastPing != 0 {
+			nodes[i].LastPingString = getSimpleDurationFormat(now().Sub(time.Unix(nodes[i].LastPing, 0)))
+		}
+	}
+
+	current.Nodes = nodes
+	return current
 }
 
 func probeLoop() {
+	var lastSourceFetch int64
+
 	for {
-		nodes, err := parseNodes()
-		if err != nil {
-			log.Printf("Error while trying to parse nodes: %s", err.Error())
-		} else {
-			c := make(chan error)
-			for e := nodes.Front(); e != nil; e = e.Next() {
-				node, _ := e.Value.(*toxNode)
-				go func() {
-					err := probeNode(node)
+		scanStart := time.Now()
+		setScanInProgress(true)
+		ctx, cancel := context.WithTimeout(context.Background(), *scanTimeoutFlag)
 
-					ports := tcpPorts
-					if !contains(tcpPorts, node.Port) {
-						ports = append(ports, node.Port)
-					}
+		nodes, err := parseNodes(ctx)
+		if err == nil {
+			lastSourceFetch = now().Unix()
+			if err := saveNodesSnapshot(sourceCachePath, nodes); err != nil {
+				slog.Warn("error saving node list cache", "error", err)
+			}
+			nodes = keepRemovedNodes(nodes)
+		} else {
+			slog.Warn("error while trying to parse nodes", "error", err)
 
-					probeNodeTCPPorts(node, ports)
+			var cacheErr error
+			nodes, lastSourceFetch, cacheErr = loadCachedNodes(sourceCachePath)
+			if cacheErr != nil {
+				slog.Warn("error loading node list cache", "error", cacheErr)
+			} else {
+				slog.Warn("running on the cached node list", "fetched_at", time.Unix(lastSourceFetch, 0))
+				nodes = filterAllowedNodes(nodes)
+				err = nil
+			}
+		}
 
-					if node.UDPStatus || node.TCPStatus {
-						node.LastPing = time.Now().Unix()
-					}
+		if err == nil {
+			transferNodeInfo(nodes)
+			probeNodes(ctx, nodes)
 
-					c <- err
-				}()
+			if ctx.Err() != nil {
+				slog.Warn("scan deadline exceeded, not all nodes have been probed", "timeout", *scanTimeoutFlag)
 			}
 
-			for i := 0; i < nodes.Len(); i++ {
-				err = <-c
-				if err != nil {
-					log.Printf("error: %s", err.Error())
+			scanTime := now().Unix()
+			if *webhookURLFlag != "" {
+				if transitions := findTransitions(nodesList, nodes); len(transitions) > 0 {
+					go sendWebhook(*webhookURLFlag, webhookPayload{scanTime, transitions})
 				}
 			}
 
 			nodesList = nodes
-			lastScan = time.Now().Unix()
+			publishStatus(nodes, scanTime, lastSourceFetch, time.Since(scanStart))
+			broadcastStatus()
+			if len(peerURLs) > 0 {
+				go updateGlobalStatus(getStatus())
+			}
+
+			if historyDB != nil {
+				if err := recordScan(scanTime, nodes); err != nil {
+					slog.Warn("error recording scan in the history database", "error", err)
+				}
+			}
+
+			if err := saveNodesSnapshot(snapshotPath, nodes); err != nil {
+				slog.Warn("error saving node snapshot", "error", err)
+			}
+		} else {
+			setScanInProgress(false)
+		}
+
+		cancel()
+		select {
+		case <-time.After(*refreshFlag):
+		case <-rescanRequests:
+			slog.Info("rescan requested")
+		}
+	}
+}
+
+//probes every node once with -workers workers, returns when all of them are done or skipped
+func probeNodes(ctx context.Context, nodes []*toxNode) {
+	jobs := make(chan *toxNode)
+	c := make(chan error)
+	for i := 0; i < *workersFlag; i++ {
+		go probeWorker(ctx, jobs, c)
+	}
+
+	//probe in a different order every scan, optionally paced over the first half of the refresh window
+	go func(order []*toxNode) {
+		var interval time.Duration
+		if *spreadFlag && len(order) > 0 {
+			interval = *refreshFlag / 2 / time.Duration(len(order))
+		}
+
+		for i, node := range order {
+			if i > 0 && interval > 0 {
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+				}
+			}
+			jobs <- node
+		}
+		close(jobs)
+	}(shuffleNodes(nodes))
+
+	for range nodes {
+		<-c
+	}
+}
+
+//a variable so tests can count the probes without touching the network
+var probeFunc = scanNode
+
+//nodes are handed to workers as values so no goroutine shares a loop variable
+//once the scan deadline has passed, the remaining nodes are skipped
+func probeWorker(ctx context.Context, jobs <-chan *toxNode, results chan<- error) {
+	for node := range jobs {
+		if ctx.Err() != nil {
+			carryOverProbeResults(node)
+			node.LastError = ctx.Err().Error()
+			results <- ctx.Err()
+			continue
+		}
+
+		err := probeFunc(node)
+		if err != nil {
+			slog.Info("node is unreachable", "public_key", node.PublicKey, "error", err)
+		}
+		results <- err
+	}
+}
+
+//a node that wasn't probed keeps the results of the previous scan instead of looking like it went down,
+//nothing is recorded in its history, the fields that come from the sources are kept as they are now
+func carryOverProbeResults(node *toxNode) {
+	oldNode := getOldNode(node.PublicKey)
+	if oldNode == nil {
+		return
+	}
+
+	probed := *oldNode
+	probed.Ipv4Address = node.Ipv4Address
+	probed.Ipv6Address = node.Ipv6Address
+	probed.Port = node.Port
+	probed.UDPPorts = node.UDPPorts
+	probed.AdvertisedTCPPorts = node.AdvertisedTCPPorts
+	probed.Maintainer = node.Maintainer
+	probed.Location = node.Location
+	probed.LocationFull = node.LocationFull
+	probed.RemovedFromSource = node.RemovedFromSource
+	probed.history = node.history
+	*node = probed
+}
+
+//probes the node over udp and tcp and updates its ping and uptime info
+func scanNode(node *toxNode) error {
+	locateNode(node)
+	if *rdnsFlag {
+		resolveHostname(node)
+	}
+	var err error
+	if probesUDP() {
+		err = probeNode(node)
+	}
+
+	if probesTCP() {
+		ports := append([]int{}, tcpPorts...)
+		for _, port := range append([]int{node.Port}, node.AdvertisedTCPPorts...) {
+			if !contains(ports, port) {
+				ports = append(ports, port)
+			}
+		}
+
+		probeNodeTCPPorts(node, ports)
+		if err == nil && !node.TCPStatus && !probesUDP() {
+			err = errors.New("no tcp port accepted the handshake")
 		}
+	}
+	node.IsRelay = node.TCPStatus
+	node.DHTOnly = probesTCP() && node.UDPStatus && !node.TCPStatus
+
+	node.LastError = ""
+	if err != nil {
+		node.LastError = err.Error()
+	}
+
+	recordProbeResult(node)
+	updateLatencyAverage(node)
+
+	return err
+}
+
+//updates the last ping, uptime history and streaks of the node with the result of this scan
+func recordProbeResult(node *toxNode) {
+	up := isNodeUp(*node)
+	if up {
+		node.LastPing = now().Unix()
+	}
+
+	if node.history == nil {
+		node.history = &uptimeHistory{}
+	}
+	node.history.record(up)
+	node.UptimePercent = node.history.percent()
+	if up {
+		node.StreakUp++
+		node.StreakDown = 0
+	} else {
+		node.StreakDown++
+		node.StreakUp = 0
+	}
+}
 
-		time.Sleep(refreshRate * time.Second)
+func probesUDP() bool {
+	return *probeModeFlag != "tcp"
+}
+
+func probesTCP() bool {
+	return *probeModeFlag != "udp"
+}
+
+//nodes are online when they answer over udp, or over tcp when udp isn't probed
+//a node that only answers over tcp while udp is probed is a relay, not online
+func isNodeUp(node toxNode) bool {
+	if probesUDP() {
+		return node.UDPStatus
+	}
+	return node.TCPStatus
+}
+
+//folds the latency of this scan into the moving average, which is held
+//and marked stale when the node didn't respond
+func updateLatencyAverage(node *toxNode) {
+	if node.LatencyMS < 0 {
+		node.LatencyAvgStale = node.LatencyAvgMS >= 0
+		return
 	}
+
+	if node.LatencyAvgMS < 0 {
+		node.LatencyAvgMS = float64(node.LatencyMS)
+	} else {
+		node.LatencyAvgMS = *ewmaFlag*float64(node.LatencyMS) + (1-*ewmaFlag)*node.LatencyAvgMS
+	}
+	node.LatencyAvgStale = false
 }
 
 func probeNodeTCPPorts(node *toxNode, ports []int) {
+	var ipv4Ports, ipv6Ports []int
+	var ipv4Verified, ipv6Verified bool
+	if isAddressSet(node.Ipv4Address) {
+		ipv4Ports, ipv4Verified = probeTCPPorts(node, node.Ipv4Address, ports)
+	}
+	if isAddressSet(node.Ipv6Address) {
+		ipv6Ports, ipv6Verified = probeTCPPorts(node, node.Ipv6Address, ports)
+	}
+
+	node.TCPPorts = append(node.TCPPorts, ipv4Ports...)
+	for _, port := range ipv6Ports {
+		if !contains(node.TCPPorts, port) {
+			node.TCPPorts = append(node.TCPPorts, port)
+		}
+	}
+
+	node.TCPStatusIpv4 = len(ipv4Ports) > 0
+	node.TCPStatusIpv6 = len(ipv6Ports) > 0
+	node.TCPStatus = len(node.TCPPorts) > 0
+	node.TCPRelayVerified = ipv4Verified || ipv6Verified
+}
+
+//returns the ports on which a tcp handshake with the given address succeeded,
+//and whether the relay answered a ping on any of them
+func probeTCPPorts(node *toxNode, address string, ports []int) ([]int, bool) {
 	c := make(chan tcpHandshakeResult)
 	for _, port := range ports {
 		go func(p int) {
-			conn, err := newNodeConn(node, p, "tcp")
+			dialSlots <- struct{}{}
+			defer func() { <-dialSlots }()
+
+			conn, err := newNodeConn(address, p, "tcp")
 			if err != nil {
-				fmt.Printf("%s\n", err.Error())
-				c <- tcpHandshakeResult{p, err}
+				c <- tcpHandshakeResult{p, err, false}
 			} else {
 				c <- tryTCPHandshake(node, conn, p)
 			}
 		}(port)
 	}
 
+	openPorts := []int{}
+	verified := false
 	for i := 0; i < len(ports); i++ {
 		result := <-c
 		if result.Error != nil {
-			fmt.Printf("%s\n", result.Error.Error())
+			slog.Debug("tcp handshake failed", "public_key", node.PublicKey, "address", address, "port", result.Port, "error", result.Error)
 		} else {
-			node.TCPPorts = append(node.TCPPorts, result.Port)
+			openPorts = append(openPorts, result.Port)
+			verified = verified || result.Verified
 		}
 	}
 
-	node.TCPStatus = len(node.TCPPorts) > 0
+	return openPorts, verified
 }
 
 func probeNodeTCP(node *toxNode) error {
-	conn, err := newNodeConn(node, node.Port, "tcp")
+	address := node.Ipv4Address
+	if !isAddressSet(address) {
+		address = node.Ipv6Address
+	}
+
+	conn, err := newNodeConn(address, node.Port, "tcp")
 	if err != nil {
 		return err
 	}
 
-	return tryTCPHandshake(node, conn, node.Port).Error
+	result := tryTCPHandshake(node, conn, node.Port)
+	node.TCPRelayVerified = result.Verified
+	return result.Error
 }
 
+//probes the node over udp on all of its known addresses
+//returns nil if the node responded on at least one of them
 func probeNode(node *toxNode) error {
-	conn, err := newNodeConn(node, node.Port, "udp")
-	if err != nil {
-		return err
+	if proxyDialer != nil {
+		return errUDPOverProxy
 	}
 
-	err = getBootstrapInfo(node, conn)
-	/*if err != nil {
-		return err
-	}*/
-	conn.Close()
+	err := errors.New("node has no address to probe")
 
-	conn, err = newNodeConn(node, node.Port, "udp")
-	if err != nil {
-		return err
+	if isAddressSet(node.Ipv4Address) {
+		err = probeNodeUDPPorts(node, node.Ipv4Address)
+		node.UDPStatusIpv4 = err == nil
 	}
 
-	err = getNodes(node, conn)
+	if isAddressSet(node.Ipv6Address) {
+		ipv6Err := probeNodeUDPPorts(node, node.Ipv6Address)
+		node.UDPStatusIpv6 = ipv6Err == nil
+		if !node.UDPStatusIpv4 {
+			err = ipv6Err
+		}
+	}
+
+	node.UDPStatus = node.UDPStatusIpv4 || node.UDPStatusIpv6
+	if node.UDPStatus {
+		return nil
+	}
+	return err
+}
+
+//probes every udp port of the node on the address, succeeds if any of them answered
+func probeNodeUDPPorts(node *toxNode, address string) error {
+	var err error
+	answered := false
+	for _, port := range getUDPPorts(node) {
+		portErr := probeNodeUDP(node, address, port)
+		if portErr != nil {
+			err = portErr
+			continue
+		}
+
+		answered = true
+		if !contains(node.OpenUDPPorts, port) {
+			node.OpenUDPPorts = append(node.OpenUDPPorts, port)
+		}
+		if node.WorkingUDPPort == 0 || node.UDPPortMismatch {
+			node.WorkingUDPPort = port
+			node.UDPPortMismatch = false
+		}
+	}
+
+	if answered {
+		return nil
+	}
+
+	//the node may have moved without its listing being updated
+	for _, port := range altUDPPorts {
+		if contains(getUDPPorts(node), port) {
+			continue
+		}
+
+		if portErr := probeNodeUDP(node, address, port); portErr == nil {
+			if node.WorkingUDPPort == 0 {
+				node.WorkingUDPPort = port
+				node.UDPPortMismatch = true
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+//nodes from older snapshots and single port sources only have Port
+func getUDPPorts(node *toxNode) []int {
+	if len(node.UDPPorts) == 0 {
+		return []int{node.Port}
+	}
+	return node.UDPPorts
+}
+
+func probeNodeUDP(node *toxNode, address string, port int) error {
+	conn, err := newNodeConn(address, port, "udp")
 	if err != nil {
-		conn.Close()
 		return err
 	}
+
+	//not every node answers bootstrap info requests, that doesn't make it any less up
+	//one that ignored it on a port or address ignores it on the others too, so don't wait for it again
+	infoTimedOut := false
+	if !node.bootstrapInfoTimedOut {
+		if err = getBootstrapInfo(node, conn); err != nil {
+			var netErr net.Error
+			infoTimedOut = errors.As(err, &netErr) && netErr.Timeout()
+			slog.Debug("no bootstrap info", "public_key", node.PublicKey, "address", address, "error", err)
+		}
+	}
 	conn.Close()
 
-	node.UDPStatus = true
-	return nil
+	//udp is lossy, so don't give up on a node after a single dropped packet
+	for attempt := 1; ; attempt++ {
+		conn, remote, err := newUnconnectedUDPConn(address, port)
+		if err != nil {
+			return err
+		}
+
+		err = getNodes(node, conn, remote)
+		conn.Close()
+		if err == nil {
+			//a timeout only says the node ignores bootstrap info if the port is reachable at all
+			node.bootstrapInfoTimedOut = node.bootstrapInfoTimedOut || infoTimedOut
+			return nil
+		}
+		if attempt >= *attemptsFlag {
+			return err
+		}
+
+		time.Sleep(addJitter(time.Duration(attempt) * probeBackoff))
+	}
 }
 
-func getNodes(node *toxNode, conn net.Conn) error {
+//conn isn't connected so the address the response came from can be compared to remote
+func getNodes(node *toxNode, conn *net.UDPConn, remote *net.UDPAddr) error {
 	nodePublicKey, err := hex.DecodeString(node.PublicKey)
 	if err != nil {
 		return err
 	}
 
-	plain := make([]byte, len(crypto.PublicKey)+8)
+	pingID := nextBytes(8)
+	plain := make([]byte, len(crypto.PublicKey)+len(pingID))
 	copy(plain, crypto.PublicKey)
-	copy(plain[len(crypto.PublicKey):], nextBytes(8)) //ping id
+	copy(plain[len(crypto.PublicKey):], pingID)
 
 	nonce := nextNonce()
+	//encryptData panics on a nil key, which a low order public key would give us
 	sharedKey := crypto.CreateSharedKey(nodePublicKey)
-	encrypted := encryptData(plain, sharedKey, nonce)[16:]
+	if sharedKey == nil {
+		return errors.New("could not compute a shared key with the public key of the node")
+	}
+	encrypted := encryptData(plain, sharedKey, nonce)
+	if encrypted == nil {
+		return errors.New("could not encrypt the getnodes request")
+	}
+	encrypted = encrypted[16:]
 
 	payload := make([]byte, 1+len(crypto.PublicKey)+len(nonce)+len(encrypted))
 	payload[0] = getNodesPacketID
 	copy(payload[1:], crypto.PublicKey)
 	copy(payload[1+len(crypto.PublicKey):], nonce)
 	copy(payload[1+len(crypto.PublicKey)+len(nonce):], encrypted)
-	conn.Write(payload)
+	conn.WriteToUDP(payload, remote)
+	sent := time.Now()
 
+	//nodes sometimes send a 'getnodes' packet of their own before 'sendnodesipv6'
+	//so skip anything else until the deadline of conn expires
 	buffer := make([]byte, maxUDPPacketSize)
-	_, err = conn.Read(buffer)
+	var packet []byte
+	var from *net.UDPAddr
+	for i := 0; i < maxGetNodesReads && packet == nil; i++ {
+		read, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return err
+		}
 
+		//the kernel drops whatever didn't fit in the buffer
+		if read == len(buffer) {
+			slog.Warn("udp packet may have been truncated", "public_key", node.PublicKey, "from", addr.String(), "size", read)
+		}
+
+		if read > 0 && buffer[0] == sendNodesIpv6PacketID {
+			packet = buffer[:read]
+			from = addr
+		}
+	}
+
+	if packet == nil {
+		return fmt.Errorf("no sendnodesipv6 packet in the first %d packets", maxGetNodesReads)
+	}
+
+	data, err := openSendNodesPacket(packet, nodePublicKey, sharedKey, pingID)
 	if err != nil {
 		return err
-	} /*else if payload[0] != sendNodesIpv6PacketID {
-		return fmt.Errorf("packet id: %d is not a sendnodesipv6 packet", payload[0])
 	}
 
-	right now we're happy if a node responds to our 'getnodes' request, without even validating the response
-	this needs some more work
+	//keep the fastest response if the node is reachable on multiple addresses
+	latency := time.Since(sent).Nanoseconds() / int64(time.Millisecond)
+	if node.LatencyMS < 0 || latency < node.LatencyMS {
+		node.LatencyMS = latency
+	}
 
-	on a side note: it looks like nodes are sending a 'getnodes' packet before 'sendnodesipv6',
-	*/
+	//a response from another address points at nat or a stale entry in the node list
+	if !from.IP.Equal(remote.IP) {
+		slog.Debug("sendnodesipv6 came from an unexpected address", "public_key", node.PublicKey, "expected", remote.IP.String(), "observed", from.IP.String())
+		node.AddressMismatch = true
+		node.ObservedAddress = from.IP.String()
+	}
 
+	node.DiscoveredNodes = parsePackedNodes(data)
+	node.NeighborCount = len(node.DiscoveredNodes)
 	return nil
 }
 
+//returns the decrypted node data of a sendnodesipv6 packet, without the ping id
+func openSendNodesPacket(packet []byte, nodePublicKey []byte, sharedKey []byte, pingID []byte) ([]byte, error) {
+	nonceSize := cryptobox.CryptoBoxNonceBytes()
+	headerSize := 1 + len(nodePublicKey) + nonceSize
+
+	if len(packet) < headerSize+cryptobox.CryptoBoxMacBytes()+1+len(pingID) {
+		return nil, errors.New("sendnodesipv6 packet too small")
+	} else if packet[0] != sendNodesIpv6PacketID {
+		return nil, fmt.Errorf("packet id: %d is not a sendnodesipv6 packet", packet[0])
+	} else if !bytes.Equal(packet[1:1+len(nodePublicKey)], nodePublicKey) {
+		return nil, errors.New("sendnodesipv6 packet was sent by a different public key")
+	}
+
+	nonce := packet[1+len(nodePublicKey) : headerSize]
+	plain, err := decryptData(packet[headerSize:], sharedKey, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("could not open sendnodesipv6 packet: %s", err)
+	}
+
+	if !bytes.Equal(plain[len(plain)-len(pingID):], pingID) {
+		return nil, errors.New("sendnodesipv6 packet has an unexpected ping id")
+	}
+
+	return plain[:len(plain)-len(pingID)], nil
+}
+
+//parses the node count and packed nodes of a decrypted sendnodesipv6 packet
+//malformed or unsupported trailing entries are skipped
+func parsePackedNodes(data []byte) []dhtNode {
+	nodes := []dhtNode{}
+	if len(data) < 1 {
+		return nodes
+	}
+
+	count := int(data[0])
+	data = data[1:]
+
+	for i := 0; i < count; i++ {
+		if len(data) < 1 {
+			break
+		}
+
+		var ipSize int
+		switch data[0] {
+		case packedNodeIPv4Family:
+			ipSize = net.IPv4len
+		case packedNodeIPv6Family:
+			ipSize = net.IPv6len
+		default:
+			return nodes
+		}
+
+		entrySize := 1 + ipSize + 2 + cryptobox.CryptoBoxPublicKeyBytes()
+		if len(data) < entrySize {
+			break
+		}
+
+		ip := net.IP(data[1 : 1+ipSize])
+		port := binary.BigEndian.Uint16(data[1+ipSize : 1+ipSize+2])
+		publicKey := data[1+ipSize+2 : entrySize]
+
+		nodes = append(nodes, dhtNode{
+			ip.String(),
+			int(port),
+			hex.EncodeToString(publicKey),
+		})
+		data = data[entrySize:]
+	}
+
+	return nodes
+}
+
+//the response is [packet id][version u32][null terminated motd], toxcore doesn't
+//define any capability flags in it, anything after the motd ends up in RawInfo
 func getBootstrapInfo(node *toxNode, conn net.Conn) error {
 	payload := make([]byte, bootstrapInfoPacketLength)
 	payload[0] = bootstrapInfoPacketID
 	conn.Write(payload)
+	sent := time.Now()
 
-	buffer := make([]byte, 1+4+maxMOTDLength)
+	buffer := make([]byte, maxUDPPacketSize) //room for anything sent after the motd
 	read, err := conn.Read(buffer)
-
 	if err != nil {
 		return err
+	}
+	rtt := time.Since(sent).Milliseconds()
+
+	//only look at what we actually received, the rest of the buffer is just zeroes
+	buffer = buffer[:read]
+	if len(buffer) == 0 {
+		return errors.New("bootstrap info packet is empty")
 	} else if buffer[0] != bootstrapInfoPacketID {
 		return fmt.Errorf("packet id: %d is not a bootstrap info packet", buffer[0])
+	} else if len(buffer) < 1+4 {
+		return fmt.Errorf("bootstrap info packet too small: %d bytes", len(buffer))
 	}
 
-	buffer = buffer[:read]
-	if len(buffer) < 1+4 {
-		return errors.New("bootstrap info packet too small")
+	node.VersionRaw = binary.BigEndian.Uint32(buffer[1 : 1+4])
+	node.Version = formatVersion(node.VersionRaw)
+	//the motd is cut at maxMOTDLength if the terminator is missing
+	motd := buffer[1+4:]
+	var rest []byte
+	if end := bytes.IndexByte(motd, 0); end >= 0 && end <= maxMOTDLength {
+		motd, rest = motd[:end], motd[end+1:]
+	} else if len(motd) > maxMOTDLength {
+		motd, rest = motd[:maxMOTDLength], motd[maxMOTDLength:]
 	}
 
-	node.Version = fmt.Sprintf("%d", binary.BigEndian.Uint32(buffer[1:1+4]))
-	node.MOTD = string(bytes.Trim(buffer[1+4:], "\x00"))
+	node.MOTD = sanitizeMOTD(string(motd))
+	node.RawInfo = hex.EncodeToString(bytes.TrimRight(rest, "\x00")) //without the zero padding
+	node.HasBootstrapInfo = true
+
+	//keep the fastest answer, like the getnodes latency
+	if node.BootstrapInfoRTTMS < 0 || rtt < node.BootstrapInfoRTTMS {
+		node.BootstrapInfoRTTMS = rtt
+	}
 	return nil
 }
 
 func tryTCPHandshake(node *toxNode, conn net.Conn, port int) tcpHandshakeResult {
-	/* NOTE: conn is closed at the end of this function */
+	/* NOTE: conn is closed when this function returns */
+	defer conn.Close()
+
 	nodePublicKey, err := hex.DecodeString(node.PublicKey)
 	if err != nil {
-		return tcpHandshakeResult{port, err}
+		return tcpHandshakeResult{port, err, false}
 	}
 
 	nonce := nextNonce()
 	baseNonce := nextNonce()
 	plain := make([]byte, len(crypto.PublicKey)+len(baseNonce))
-	tempCrypto, _ := NewCrypto()
+	tempCrypto, err := NewCrypto()
+	if err != nil {
+		return tcpHandshakeResult{port, fmt.Errorf("could not generate a temporary keypair: %s", err), false}
+	}
 
 	copy(plain, tempCrypto.PublicKey)
 	copy(plain[len(tempCrypto.PublicKey):], baseNonce)
 	sharedKey := crypto.CreateSharedKey(nodePublicKey)
-	encrypted := encryptData(plain, sharedKey, nonce)[16:]
+	if sharedKey == nil {
+		return tcpHandshakeResult{port, errors.New("could not compute a shared key with the public key of the node"), false}
+	}
+	encrypted := encryptData(plain, sharedKey, nonce)
+	if encrypted == nil {
+		return tcpHandshakeResult{port, errors.New("could not encrypt the handshake"), false}
+	}
+	encrypted = encrypted[16:]
 
 	payload := make([]byte, tcpHandshakePacketLength)
 	copy(payload, crypto.PublicKey)
@@ -391,138 +1455,414 @@ func tryTCPHandshake(node *toxNode, conn net.Conn, port int) tcpHandshakeResult
 	copy(payload[len(crypto.PublicKey)+len(nonce):], encrypted)
 	conn.Write(payload)
 
+	//tcp is a stream, the response may arrive in several segments
 	buffer := make([]byte, tcpHandshakeResponsePacketLength)
-	read, err := conn.Read(buffer)
+	read, err := io.ReadFull(conn, buffer)
 
 	var result tcpHandshakeResult
 
-	if err != nil {
-		result = tcpHandshakeResult{port, err}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		result = tcpHandshakeResult{port, err, false}
 	} else if read != tcpHandshakeResponsePacketLength {
 		result = tcpHandshakeResult{
 			port,
 			errors.New("tcp handshake response had an invalid length"),
+			false,
 		}
-	} else if isValidHandshakeResponse(buffer, baseNonce, sharedKey, tempCrypto) {
-		result = tcpHandshakeResult{
-			port,
-			errors.New("tcp handshake response is incorrect"),
-		}
+	} else if serverPublicKey, serverBaseNonce, err := openHandshakeResponse(buffer, sharedKey); err != nil {
+		result = tcpHandshakeResult{port, err, false}
 	} else {
-		result = tcpHandshakeResult{port, nil}
+		//the handshake had its own deadline, the ping gets a fresh one
+		conn.SetDeadline(time.Now().Add(addJitter(*tcpTimeoutFlag)))
+		err := verifyTCPRelay(conn, tempCrypto.CreateSharedKey(serverPublicKey), baseNonce, serverBaseNonce)
+		if err != nil {
+			slog.Debug("tcp relay not verified", "public_key", node.PublicKey, "port", port, "error", err)
+		}
+		result = tcpHandshakeResult{port, nil, err == nil}
 	}
 
-	conn.Close()
 	return result
 }
 
-func isValidHandshakeResponse(data []byte, baseNonce []byte, sharedKey []byte, tempPair *Crypto) bool {
+//decrypts a tcp handshake response and returns the temporary public key and base nonce of the server
+//only the owner of the node's secret key can produce a response that decrypts with our shared key
+func openHandshakeResponse(data []byte, sharedKey []byte) ([]byte, []byte, error) {
 	nonceSize := cryptobox.CryptoBoxNonceBytes()
+	publicKeySize := cryptobox.CryptoBoxPublicKeyBytes()
 	nonce := data[:nonceSize]
 	encrypted := data[nonceSize:]
 
-	decrypted := decryptData(encrypted, sharedKey, nonce)
-	if decrypted == nil {
-		return false
+	plain, err := decryptData(encrypted, sharedKey, nonce)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tcp handshake response could not be opened, this is not a tox relay for this public key: %s", err)
 	}
 
-	serverBaseNonce := decrypted[:nonceSize]
-	tempPublicKey := decrypted[nonceSize:]
+	if len(plain) != publicKeySize+nonceSize {
+		return nil, nil, fmt.Errorf("tcp handshake response has an unexpected payload size: %d", len(plain))
+	}
 
-	if !bytes.Equal(tempPair.PublicKey, tempPublicKey) {
-		return false
+	tempPublicKey := plain[:publicKeySize]
+	serverBaseNonce := plain[publicKeySize:]
+	return tempPublicKey, serverBaseNonce, nil
+}
+
+//sends a ping over the session established by the handshake and waits for the pong, a port
+//that completes the handshake but doesn't answer it isn't a working relay
+//the relay protocol has no getnodes, a ping is the simplest request every relay has to answer
+func verifyTCPRelay(conn net.Conn, sessionKey []byte, sendNonce []byte, receiveNonce []byte) error {
+	if sessionKey == nil {
+		return errors.New("could not compute the session key")
 	}
 
-	if !bytes.Equal(baseNonce, serverBaseNonce) {
-		return false
+	//a zero ping id is ignored by clients, so don't risk it being special to servers either
+	pingID := nextBytes(tcpPingIDLength)
+	pingID[0] |= 1
+	encrypted := encryptData(append([]byte{tcpPingPacketID}, pingID...), sessionKey, sendNonce)
+	if encrypted == nil {
+		return errors.New("could not encrypt the ping")
 	}
+	encrypted = encrypted[16:]
 
-	return true
+	packet := make([]byte, 2+len(encrypted))
+	binary.BigEndian.PutUint16(packet, uint16(len(encrypted)))
+	copy(packet[2:], encrypted)
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+
+	//the server may send packets of its own first, like pings
+	for i := 0; i < maxTCPRelayReads; i++ {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return err
+		}
+
+		length := int(binary.BigEndian.Uint16(header))
+		if length > maxTCPPacketSize {
+			return fmt.Errorf("relay packet too big: %d bytes", length)
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return err
+		}
+
+		plain, err := decryptData(data, sessionKey, receiveNonce)
+		if err != nil {
+			return fmt.Errorf("relay packet could not be opened: %s", err)
+		}
+		incrementNonce(receiveNonce)
+
+		if len(plain) == 1+tcpPingIDLength && plain[0] == tcpPongPacketID && bytes.Equal(plain[1:], pingID) {
+			return nil
+		}
+	}
+
+	return errors.New("relay didn't answer the ping")
 }
 
-func newNodeConn(node *toxNode, port int, network string) (net.Conn, error) {
+func newNodeConn(address string, port int, network string) (net.Conn, error) {
 	dialer := net.Dialer{}
-	dialer.Deadline = time.Now().Add(dialerTimeout * time.Second)
+	dialer.Deadline = time.Now().Add(*dialTimeoutFlag)
 
-	conn, err := dialer.Dial(network, fmt.Sprintf("%s:%d", node.Ipv4Address, port))
+	var conn net.Conn
+	var err error
+	if proxyDialer == nil {
+		if address, err = resolveNodeAddress(address, dialer.Deadline); err == nil {
+			conn, err = dialer.Dial(network, net.JoinHostPort(address, strconv.Itoa(port)))
+		}
+	} else if network == "tcp" {
+		conn, err = dialProxy(net.JoinHostPort(address, strconv.Itoa(port)), dialer.Deadline)
+	} else {
+		err = errUDPOverProxy
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	conn.SetReadDeadline(time.Now().Add(queryTimeout * time.Second))
+	//every connection gets its own deadline, which also bounds writes on tcp
+	conn.SetDeadline(time.Now().Add(addJitter(getQueryTimeout(network))))
 	return conn, nil
 }
 
-func parseNode(nodeString string) *toxNode {
+//resolves hostnames separately from dialing so a broken dns entry isn't reported as a closed port
+func resolveNodeAddress(address string, deadline time.Time) (string, error) {
+	if net.ParseIP(address) != nil {
+		return address, nil
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, address)
+	if err != nil {
+		return "", fmt.Errorf("dns lookup failed: %s", err)
+	} else if len(addresses) == 0 {
+		return "", fmt.Errorf("dns lookup failed: no addresses for %s", address)
+	}
+
+	return addresses[0].IP.String(), nil
+}
+
+func getQueryTimeout(network string) time.Duration {
+	if network == "tcp" {
+		return *tcpTimeoutFlag
+	}
+	return *udpTimeoutFlag
+}
+
+//like newNodeConn, but returns a socket that accepts packets from any address
+func newUnconnectedUDPConn(address string, port int) (*net.UDPConn, *net.UDPAddr, error) {
+	address, err := resolveNodeAddress(address, time.Now().Add(*dialTimeoutFlag))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	remote, err := net.ResolveUDPAddr("udp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(addJitter(*udpTimeoutFlag)))
+	return conn, remote, nil
+}
+
+//parses a row of the wiki table, the header tells which column is where
+func parseNode(nodeString string, header wikiHeader) *toxNode {
 	nodeString = stripSpaces(nodeString)
-	if !strings.HasPrefix(nodeString, "|") {
+	if !strings.HasPrefix(nodeString, "|") || header.skip {
 		return nil
 	}
 
 	lineParts := strings.Split(nodeString, "|")
-	if port, err := strconv.Atoi(lineParts[3]); err == nil && len(lineParts) == 8 {
-		node := toxNode{
-			lineParts[1],
-			lineParts[2],
-			port,
-			[]int{},
-			lineParts[4],
-			lineParts[5],
-			lineParts[6],
-			countries[lineParts[6]],
-			false,
-			false,
-			"",
-			"",
-			0,
-			"Never",
-		}
+	if !header.matches(len(lineParts)) {
+		slog.Warn("skipping wiki row that doesn't match the table header", "line", nodeString)
+		return nil
+	}
 
-		if node.Ipv6Address == "NONE" {
-			node.Ipv6Address = "-"
-		}
+	//some nodes listen on several ports, which are listed comma separated
+	ports := parsePorts(header.get(lineParts, "port"))
+	if len(ports) == 0 {
+		slog.Warn("skipping wiki row without a valid port", "line", nodeString)
+		return nil
+	}
 
-		return &node
+	publicKey, err := normalizePublicKey(header.get(lineParts, "publickey"))
+	if err != nil {
+		slog.Warn("skipping node with an invalid public key", "line", nodeString, "error", err)
+		return nil
 	}
 
+	node := newToxNode(header.get(lineParts, "ipv4"), header.get(lineParts, "ipv6"), ports[0], publicKey,
+		header.get(lineParts, "maintainer"), header.get(lineParts, "location"))
+	node.UDPPorts = ports
+	node.AdvertisedTCPPorts = parsePorts(header.get(lineParts, "tcpports"))
+	return node
+}
+
+//like parsePorts, but fails on the first invalid entry instead of skipping it
+func validatePorts(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port: %q", part)
+		}
+	}
 	return nil
 }
 
-func parseNodes() (*list.List, error) {
-	res, err := http.Get(wikiURI)
-	if err != nil {
-		return nil, err
+//parses a comma separated list of ports, skipping invalid entries
+func parsePorts(s string) []int {
+	ports := []int{}
+	for _, part := range strings.Split(s, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(part))
+		if err == nil && port > 0 && port <= 65535 && !contains(ports, port) {
+			ports = append(ports, port)
+		}
 	}
-	defer res.Body.Close()
+	return ports
+}
 
-	nodes := list.New()
-	content, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+func newToxNode(ipv4 string, ipv6 string, port int, publicKey string, maintainer string, location string) *toxNode {
+	node := toxNode{
+		Ipv4Address:        ipv4,
+		Ipv6Address:        ipv6,
+		Port:               port,
+		UDPPorts:           []int{port},
+		OpenUDPPorts:       []int{},
+		TCPPorts:           []int{},
+		AdvertisedTCPPorts: []int{},
+		PublicKey:          publicKey,
+		Maintainer:         maintainer,
+		Location:           location,
+		LocationFull:       countries[location],
+		LastPingString:     "Never",
+		LatencyMS:          -1,
+		BootstrapInfoRTTMS: -1,
+		LatencyAvgMS:       -1,
+		DiscoveredNodes:    []dhtNode{},
 	}
 
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		node := parseNode(line)
-		if node == nil {
+	if node.Ipv6Address == "NONE" || node.Ipv6Address == "" {
+		node.Ipv6Address = "-"
+	}
+
+	return &node
+}
+
+//fetches the nodes of all sources and merges them by public key, entries that
+//disagree are published as conflicts, only fails if none of the sources could be fetched
+func parseNodes(ctx context.Context) ([]*toxNode, error) {
+	nodes := []*toxNode{}
+	found := []nodeConflict{}
+	skipped := 0
+	fetched := false
+	var err error
+
+	for _, source := range nodeSources {
+		sourceNodes, sourceErr := source.Fetch(ctx)
+		if sourceErr != nil {
+			slog.Warn("error fetching nodes", "source", fmt.Sprint(source), "error", sourceErr)
+			err = sourceErr
 			continue
 		}
+		fetched = true
+
+		for _, node := range sourceNodes {
+			if !isKeyAllowed(node.PublicKey) {
+				continue
+			}
+
+			if existing := findNode(nodes, node.PublicKey); existing != nil {
+				for _, conflict := range findConflicts(existing, node) {
+					slog.Warn("node sources disagree about a node", "public_key", conflict.PublicKey, "field", conflict.Field, "values", conflict.Values)
+					found = append(found, conflict)
+				}
+			} else if len(nodes) >= *maxNodesFlag {
+				skipped++
+				continue
+			}
+			nodes = addNode(nodes, node)
+		}
+	}
+
+	if !fetched {
+		return nil, err
+	}
+
+	//a broken or malicious source shouldn't make us probe an unbounded number of nodes
+	if skipped > 0 {
+		slog.Warn("node limit reached, skipping the remaining nodes", "max_nodes", *maxNodesFlag, "skipped", skipped)
+	}
 
+	conflicts.Store(found)
+	return nodes, nil
+}
+
+//carries over the info we collected about nodes during previous scans
+//the nodes of every scan are freshly allocated by parseNodes, so only values are copied over
+func transferNodeInfo(nodes []*toxNode) {
+	for _, node := range nodes {
 		oldNode := getOldNode(node.PublicKey)
-		if oldNode != nil { //transfer last ping info
+		if oldNode != nil { //transfer last ping info, latency average, streaks and uptime history
 			node.LastPing = oldNode.LastPing
 			node.LastPingString = oldNode.LastPingString
+			node.FirstSeen = oldNode.FirstSeen
+			node.LatencyAvgMS = oldNode.LatencyAvgMS
+			node.LatencyAvgStale = oldNode.LatencyAvgStale
+			node.StreakUp = oldNode.StreakUp
+			node.StreakDown = oldNode.StreakDown
+			node.history = oldNode.history.clone() //the old node must stay as it was published
 		}
 
-		nodes.PushBack(node)
+		if node.FirstSeen == 0 {
+			node.FirstSeen = now().Unix()
+		}
 	}
-	return nodes, nil
+}
+
+//keeps probing nodes that were removed from the sources for -evict-after, so a
+//transient glitch of a source doesn't erase them, they're dropped after that
+func keepRemovedNodes(nodes []*toxNode) []*toxNode {
+	for _, oldNode := range nodesList {
+		if len(nodes) >= *maxNodesFlag {
+			break
+		} else if findNode(nodes, oldNode.PublicKey) != nil || !isKeyAllowed(oldNode.PublicKey) {
+			continue
+		}
+
+		removed := oldNode.RemovedFromSource
+		if removed == 0 {
+			removed = now().Unix()
+		}
+		if now().Sub(time.Unix(removed, 0)) >= *evictAfterFlag {
+			slog.Info("evicting node that was removed from the sources", "public_key", oldNode.PublicKey)
+			continue
+		}
+
+		//a fresh node, the old one is still being served
+		node := newToxNode(oldNode.Ipv4Address, oldNode.Ipv6Address, oldNode.Port, oldNode.PublicKey, oldNode.Maintainer, oldNode.Location)
+		node.UDPPorts = append([]int{}, getUDPPorts(oldNode)...)
+		node.AdvertisedTCPPorts = append([]int{}, oldNode.AdvertisedTCPPorts...)
+		node.RemovedFromSource = removed
+		nodes = append(nodes, node)
+	}
+	return nodes
 }
 
 func getOldNode(publicKey string) *toxNode {
-	for e := nodesList.Front(); e != nil; e = e.Next() {
-		node, _ := e.Value.(*toxNode)
-		if node.PublicKey == publicKey {
+	return findNode(nodesList, publicKey)
+}
+
+//adds the node to the list, or merges it into the node with the same public key
+//returns the updated list, like append
+func addNode(nodes []*toxNode, node *toxNode) []*toxNode {
+	if existing := findNode(nodes, node.PublicKey); existing != nil {
+		mergeNode(existing, node)
+		return nodes
+	}
+	return append(nodes, node)
+}
+
+//fills in the info dst is missing from src, dst wins on conflicts
+func mergeNode(dst *toxNode, src *toxNode) {
+	if !isAddressSet(dst.Ipv4Address) {
+		dst.Ipv4Address = src.Ipv4Address
+	}
+	if !isAddressSet(dst.Ipv6Address) {
+		dst.Ipv6Address = src.Ipv6Address
+	}
+	if dst.Port == 0 {
+		dst.Port = src.Port
+	}
+	for _, port := range src.UDPPorts {
+		if !contains(dst.UDPPorts, port) {
+			dst.UDPPorts = append(dst.UDPPorts, port)
+		}
+	}
+	for _, port := range src.AdvertisedTCPPorts {
+		if !contains(dst.AdvertisedTCPPorts, port) {
+			dst.AdvertisedTCPPorts = append(dst.AdvertisedTCPPorts, port)
+		}
+	}
+	if dst.Maintainer == "" {
+		dst.Maintainer = src.Maintainer
+	}
+	if dst.Location == "" {
+		dst.Location = src.Location
+		dst.LocationFull = src.LocationFull
+	}
+}
+
+//keys are compared case insensitively, snapshots may predate lowercased keys
+func findNode(nodes []*toxNode, publicKey string) *toxNode {
+	for _, node := range nodes {
+		if strings.EqualFold(node.PublicKey, publicKey) {
 			return node
 		}
 	}
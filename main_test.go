@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScanDeadlineAbortsSlowSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	sources := nodeSources
+	nodeSources = []NodeSource{wikiSource{server.URL}}
+	sourceClient = server.Client()
+	defer func() { nodeSources = sources }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := parseNodes(ctx); err == nil {
+		t.Fatal("expected the slow source to fail the scan")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("scan took %s, past its deadline", elapsed)
+	}
+}
+
+func TestSkippedNodesKeepTheirStatus(t *testing.T) {
+	oldNode := newToxNode("127.0.0.1", "", 33445, "ab", "old maintainer", "")
+	oldNode.UDPStatus = true
+	oldNode.UptimePercent = 99
+	oldNodes := nodesList
+	nodesList = []*toxNode{oldNode}
+	defer func() { nodesList = oldNodes }()
+
+	node := newToxNode("127.0.0.2", "", 33445, "ab", "new maintainer", "")
+	transferNodeInfo([]*toxNode{node})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	jobs := make(chan *toxNode, 1)
+	results := make(chan error, 1)
+	jobs <- node
+	close(jobs)
+	probeWorker(ctx, jobs, results)
+
+	if err := <-results; err == nil {
+		t.Error("expected the skipped node to report the scan deadline")
+	}
+	if !node.UDPStatus || node.UptimePercent != 99 {
+		t.Error("skipped node lost the status of the previous scan")
+	}
+	if node.Ipv4Address != "127.0.0.2" || node.Maintainer != "new maintainer" {
+		t.Error("skipped node lost what the sources say about it")
+	}
+	if transitions := findTransitions(nodesList, []*toxNode{node}); len(transitions) != 0 {
+		t.Errorf("skipped node caused transitions: %v", transitions)
+	}
+}
+
+//a udp connection that answers every write with a canned packet
+type mockPacketConn struct {
+	net.Conn
+	response []byte
+}
+
+func (c *mockPacketConn) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (c *mockPacketConn) Read(buffer []byte) (int, error) {
+	return copy(buffer, c.response), nil
+}
+
+func TestBootstrapInfoShortReads(t *testing.T) {
+	tests := []struct {
+		response []byte
+		err      string
+	}{
+		{[]byte{bootstrapInfoPacketID}, "bootstrap info packet too small: 1 bytes"},
+		{[]byte{bootstrapInfoPacketID, 0, 0, 0}, "bootstrap info packet too small: 4 bytes"},
+		{[]byte{bootstrapInfoPacketID, 0, 0, 0x0f, 0xa1}, ""},
+		{[]byte{1, 0, 0, 0, 0}, "packet id: 1 is not a bootstrap info packet"},
+	}
+
+	for _, test := range tests {
+		node := newToxNode("127.0.0.1", "", 33445, "ab", "", "")
+		err := getBootstrapInfo(node, &mockPacketConn{response: test.response})
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%d bytes: %s", len(test.response), err)
+			} else if node.VersionRaw != 0xfa1 || !node.HasBootstrapInfo || node.MOTD != "" {
+				t.Errorf("%d bytes: unexpected bootstrap info %d %q", len(test.response), node.VersionRaw, node.MOTD)
+			}
+		} else if err == nil || err.Error() != test.err {
+			t.Errorf("%d bytes: expected error %q, got %v", len(test.response), test.err, err)
+		}
+	}
+}
+
+func TestAssetPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0600)
+	os.Mkdir(filepath.Join(dir, "assets"), 0700)
+	os.WriteFile(filepath.Join(dir, "assets", "style.css"), []byte("body {}"), 0600)
+
+	oldAssets := assets
+	assets = os.DirFS(filepath.Join(dir, "assets"))
+	defer func() { assets = oldAssets }()
+
+	for _, target := range []string{"/../secret.txt", "/%2e%2e/secret.txt", "/css/../../secret.txt", "/..%2fsecret.txt", "//../secret.txt"} {
+		recorder := httptest.NewRecorder()
+		handleHTTPRequest(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", target, recorder.Code)
+		}
+		if strings.Contains(recorder.Body.String(), "secret") {
+			t.Errorf("%s: leaked a file outside the assets", target)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handleHTTPRequest(recorder, httptest.NewRequest(http.MethodGet, "/style.css", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("expected the stylesheet as text/css, got %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+}
+
+func TestEmbeddedAssetsLastModified(t *testing.T) {
+	oldAssets := assets
+	var err error
+	if assets, err = loadAssets(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { assets = oldAssets }()
+
+	recorder := httptest.NewRecorder()
+	handleHTTPRequest(recorder, httptest.NewRequest(http.MethodGet, "/css/style.css", nil))
+	lastModified := recorder.Header().Get("Last-Modified")
+	if recorder.Code != http.StatusOK || lastModified == "" {
+		t.Fatalf("expected the stylesheet with a Last-Modified header, got %d %q", recorder.Code, lastModified)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/css/style.css", nil)
+	request.Header.Set("If-Modified-Since", lastModified)
+	recorder = httptest.NewRecorder()
+	handleHTTPRequest(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for an unchanged asset, got %d", recorder.Code)
+	}
+}
+
+func TestETagDiffersWithGzip(t *testing.T) {
+	get := func(encoding string, etag string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/json", nil)
+		request.Header.Set("Accept-Encoding", encoding)
+		request.Header.Set("If-None-Match", etag)
+		recorder := httptest.NewRecorder()
+		writeWithETag(recorder, request, []byte("{}"), "application/json")
+		return recorder
+	}
+
+	identity := get("", "").Header().Get("ETag")
+	gzipped := get("gzip", "").Header().Get("ETag")
+	if identity == gzipped {
+		t.Fatalf("expected different etags for both encodings, got %s", identity)
+	}
+
+	if code := get("", gzipped).Code; code != http.StatusOK {
+		t.Errorf("expected the etag of the gzipped response not to match the identity one, got %d", code)
+	}
+	if code := get("gzip", gzipped).Code; code != http.StatusNotModified {
+		t.Errorf("expected 304 for the gzipped response, got %d", code)
+	}
+}
+
+func TestMOTDIsEscaped(t *testing.T) {
+	oldAssets := assets
+	var err error
+	if assets, err = loadAssets(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { assets = oldAssets }()
+
+	node := newToxNode("127.0.0.1", "", 33445, "ab", "", "")
+	node.HasBootstrapInfo = true
+	node.Version = "1"
+	node.MOTD = `<script>alert("motd")</script>`
+	publishStatus([]*toxNode{node}, 1, 1, 0)
+
+	recorder := httptest.NewRecorder()
+	handleHTTPRequest(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected the status page, got %d", recorder.Code)
+	}
+	if strings.Contains(body, `<script>alert(`) {
+		t.Error("the motd was rendered unescaped")
+	}
+	if !strings.Contains(body, `&lt;script&gt;alert(`) {
+		t.Error("the escaped motd is missing from the page")
+	}
+}
+
+func TestDuplicateNodesAreMerged(t *testing.T) {
+	key := strings.Repeat("0123456789ABCDEF", 4)
+	other := strings.Repeat("fedcba9876543210", 4)
+	page := strings.Join([]string{
+		"| 192.0.2.1 | - | 33445 | " + key + " | alice | US | 443 |",
+		"| - | 2001:db8::1 | 3389 | " + strings.ToLower(key) + " | alice | US | 33445 |",
+		"| 192.0.2.2 | - | 33445 | " + other + " | bob | DE | 443 |",
+	}, "\n")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	sources := nodeSources
+	nodeSources = []NodeSource{wikiSource{server.URL}}
+	sourceClient = server.Client()
+	defer func() { nodeSources = sources }()
+
+	nodes, err := parseNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expected one node per key, got %d", len(nodes))
+	}
+
+	node := findNode(nodes, key)
+	if node == nil {
+		t.Fatal("merged node is missing")
+	}
+	if node.Ipv4Address != "192.0.2.1" || node.Ipv6Address != "2001:db8::1" {
+		t.Errorf("addresses weren't merged: %q and %q", node.Ipv4Address, node.Ipv6Address)
+	}
+	if len(node.UDPPorts) != 2 || !contains(node.UDPPorts, 33445) || !contains(node.UDPPorts, 3389) {
+		t.Errorf("udp ports weren't merged: %v", node.UDPPorts)
+	}
+	if len(node.AdvertisedTCPPorts) != 2 || !contains(node.AdvertisedTCPPorts, 443) || !contains(node.AdvertisedTCPPorts, 33445) {
+		t.Errorf("tcp ports weren't merged: %v", node.AdvertisedTCPPorts)
+	}
+}
+
+func TestStreaks(t *testing.T) {
+	oldNodes := nodesList
+	defer func() { nodesList = oldNodes }()
+	nodesList = nil
+
+	tests := []struct {
+		up         bool
+		streakUp   int
+		streakDown int
+	}{
+		{true, 1, 0},
+		{true, 2, 0},
+		{false, 0, 1},
+		{false, 0, 2},
+		{false, 0, 3},
+		{true, 1, 0},
+		{false, 0, 1},
+	}
+
+	//every scan starts from a fresh node that inherits from the previous one
+	for i, test := range tests {
+		node := newToxNode("192.0.2.1", "", 33445, "ab", "", "")
+		transferNodeInfo([]*toxNode{node})
+		node.UDPStatus = test.up
+		recordProbeResult(node)
+
+		if node.StreakUp != test.streakUp || node.StreakDown != test.streakDown {
+			t.Errorf("scan %d: expected streaks %d up and %d down, got %d and %d", i, test.streakUp, test.streakDown, node.StreakUp, node.StreakDown)
+		}
+		nodesList = []*toxNode{node}
+	}
+}
+
+//a node that only accepts tcp handshakes while udp is probed is a relay, every view has to report it down
+func TestRelayOnlyNodeIsDown(t *testing.T) {
+	oldNodes, oldPeers, oldMode := nodesList, peerURLs, *probeModeFlag
+	defer func() { nodesList, peerURLs, *probeModeFlag = oldNodes, oldPeers, oldMode }()
+	nodesList, peerURLs = nil, nil
+
+	for _, mode := range []string{"both", "tcp"} {
+		*probeModeFlag = mode
+		node := newToxNode("192.0.2.1", "", 33445, "ab", "", "")
+		node.TCPStatus = true
+		recordProbeResult(node)
+
+		up := mode == "tcp"
+		if (node.StreakUp == 1) != up || (node.LastPing != 0) != up || (node.UptimePercent == 100) != up {
+			t.Errorf("%s: expected up %t, got streak %d up, last ping %d and uptime %.0f", mode, up, node.StreakUp, node.LastPing, node.UptimePercent)
+		}
+
+		updateGlobalStatus(toxStatus{Nodes: []toxNode{*node}})
+		if online := globalStatus.Load().(globalView).Nodes[0].Online; online != up {
+			t.Errorf("%s: expected the global view to report online %t, got %t", mode, up, online)
+		}
+	}
+}
+
+func TestEveryNodeIsProbedOnce(t *testing.T) {
+	var mutex sync.Mutex
+	probes := map[string]int{}
+	probe := probeFunc
+	probeFunc = func(node *toxNode) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		probes[node.PublicKey]++
+		return nil
+	}
+	defer func() { probeFunc = probe }()
+
+	nodes := []*toxNode{}
+	for i := 0; i < 50; i++ {
+		nodes = append(nodes, newToxNode("192.0.2.1", "", 33445, fmt.Sprintf("%064x", i), "", ""))
+	}
+	probeNodes(context.Background(), nodes)
+
+	if len(probes) != len(nodes) {
+		t.Errorf("expected %d nodes to be probed, got %d", len(nodes), len(probes))
+	}
+	for key, count := range probes {
+		if count != 1 {
+			t.Errorf("%s was probed %d times", key, count)
+		}
+	}
+}
+
+//answers tcp handshakes and pings like a tox relay with the given keypair
+func serveFakeRelay(listener net.Listener, relay *Crypto) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			request := make([]byte, tcpHandshakePacketLength)
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return
+			}
+			sharedKey := relay.CreateSharedKey(request[:32])
+			plain, err := decryptData(request[32+24:], sharedKey, request[32:32+24])
+			if err != nil {
+				return
+			}
+			clientPublicKey, clientBaseNonce := plain[:32], plain[32:]
+
+			temp, _ := NewCrypto()
+			baseNonce := nextNonce()
+			nonce := nextNonce()
+			encrypted := encryptData(append(append([]byte{}, temp.PublicKey...), baseNonce...), sharedKey, nonce)[16:]
+			conn.Write(append(nonce, encrypted...))
+
+			sessionKey := temp.CreateSharedKey(clientPublicKey)
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			packet := make([]byte, binary.BigEndian.Uint16(header))
+			if _, err := io.ReadFull(conn, packet); err != nil {
+				return
+			}
+			ping, err := decryptData(packet, sessionKey, clientBaseNonce)
+			if err != nil || ping[0] != tcpPingPacketID {
+				return
+			}
+
+			pong := encryptData(append([]byte{tcpPongPacketID}, ping[1:]...), sessionKey, baseNonce)[16:]
+			binary.BigEndian.PutUint16(header, uint16(len(pong)))
+			conn.Write(append(header, pong...))
+		}()
+	}
+}
+
+//run with -race, every port of the node is probed from its own goroutine
+func TestConcurrentTCPProbes(t *testing.T) {
+	relay, err := NewCrypto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialSlots = make(chan struct{}, maxDials)
+
+	ports := []int{}
+	for i := 0; i < 8; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go serveFakeRelay(listener, relay)
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	//a closed port next to the open ones
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	node := newToxNode("127.0.0.1", "", ports[0], hex.EncodeToString(relay.PublicKey), "", "")
+	probeNodeTCPPorts(node, append(ports, closedPort))
+
+	if !node.TCPStatus || !node.TCPRelayVerified {
+		t.Errorf("expected a verified relay, got status %t and verified %t", node.TCPStatus, node.TCPRelayVerified)
+	}
+	if len(node.TCPPorts) != len(ports) {
+		t.Errorf("expected the %d open ports, got %v", len(ports), node.TCPPorts)
+	}
+	for _, port := range ports {
+		if !contains(node.TCPPorts, port) {
+			t.Errorf("port %d is missing", port)
+		}
+	}
+}
+
+//answers getnodes requests with an empty sendnodesipv6 like a dht node with the given keypair
+//and bootstrap info requests if answerInfo is set, returns how many of those it received
+func serveFakeDHTNode(conn net.PacketConn, node *Crypto, answerInfo bool) func() int {
+	var mutex sync.Mutex
+	infoRequests := 0
+
+	go func() {
+		buffer := make([]byte, maxUDPPacketSize)
+		for {
+			read, from, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			request := buffer[:read]
+
+			if read > 0 && request[0] == bootstrapInfoPacketID {
+				mutex.Lock()
+				infoRequests++
+				mutex.Unlock()
+				if answerInfo {
+					info := append([]byte{bootstrapInfoPacketID, 0, 0, 0, 1}, "motd\x00"...)
+					conn.WriteTo(info, from)
+				}
+				continue
+			}
+
+			if read < 1+32+24 || request[0] != getNodesPacketID {
+				continue
+			}
+			sharedKey := node.CreateSharedKey(request[1:33])
+			plain, err := decryptData(request[1+32+24:], sharedKey, request[33:33+24])
+			if err != nil {
+				continue
+			}
+			pingID := plain[32:]
+
+			nonce := nextNonce()
+			encrypted := encryptData(append([]byte{0}, pingID...), sharedKey, nonce)[16:]
+			response := append(append(append([]byte{sendNodesIpv6PacketID}, node.PublicKey...), nonce...), encrypted...)
+			conn.WriteTo(response, from)
+		}
+	}()
+
+	return func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return infoRequests
+	}
+}
+
+func listenUDP(t *testing.T) (net.PacketConn, int) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func setFastUDPProbes(t *testing.T) {
+	oldTimeout, oldAttempts, oldAltPorts := *udpTimeoutFlag, *attemptsFlag, altUDPPorts
+	*udpTimeoutFlag, *attemptsFlag, altUDPPorts = 100*time.Millisecond, 1, nil
+	t.Cleanup(func() { *udpTimeoutFlag, *attemptsFlag, altUDPPorts = oldTimeout, oldAttempts, oldAltPorts })
+}
+
+func TestBootstrapInfoTimeoutIsNotRetried(t *testing.T) {
+	setFastUDPProbes(t)
+	dht, err := NewCrypto()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ports []int
+	var counters []func() int
+	for i := 0; i < 2; i++ {
+		conn, port := listenUDP(t)
+		counters = append(counters, serveFakeDHTNode(conn, dht, false))
+		ports = append(ports, port)
+	}
+
+	node := newToxNode("127.0.0.1", "", ports[0], hex.EncodeToString(dht.PublicKey), "", "")
+	node.UDPPorts = ports
+	if err := probeNode(node); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests := counters[0]() + counters[1](); requests != 1 {
+		t.Errorf("expected a single bootstrap info request, got %d", requests)
+	}
+	if node.HasBootstrapInfo {
+		t.Error("expected no bootstrap info from a node that ignores the requests")
+	}
+}
+
+//a dead port says nothing about whether the node answers bootstrap info on the others
+func TestBootstrapInfoAfterDeadPort(t *testing.T) {
+	setFastUDPProbes(t)
+	dht, err := NewCrypto()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, deadPort := listenUDP(t) //nothing reads from it
+	conn, port := listenUDP(t)
+	serveFakeDHTNode(conn, dht, true)
+
+	node := newToxNode("127.0.0.1", "", deadPort, hex.EncodeToString(dht.PublicKey), "", "")
+	node.UDPPorts = []int{deadPort, port}
+	if err := probeNode(node); err != nil {
+		t.Fatal(err)
+	}
+
+	if !node.HasBootstrapInfo || node.MOTD != "motd" {
+		t.Errorf("expected the bootstrap info of the second port, got %t %q", node.HasBootstrapInfo, node.MOTD)
+	}
+}
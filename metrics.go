@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//upper bounds of the latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2}
+
+//serves the current status in the prometheus text exposition format
+func handleMetricsRequest(w http.ResponseWriter, r *http.Request) {
+	current := getStatus()
+	nodes := current.Nodes
+
+	online := 0
+	for _, node := range nodes {
+		if isNodeUp(node) {
+			online++
+		}
+	}
+
+	var buffer bytes.Buffer
+	writeMetricHeader(&buffer, "toxstatus_nodes_total", "gauge", "Number of known bootstrap nodes.")
+	fmt.Fprintf(&buffer, "toxstatus_nodes_total %d\n", len(nodes))
+
+	writeMetricHeader(&buffer, "toxstatus_nodes_online", "gauge", "Number of bootstrap nodes that responded over the probed transports.")
+	fmt.Fprintf(&buffer, "toxstatus_nodes_online %d\n", online)
+
+	writeMetricHeader(&buffer, "toxstatus_last_scan_timestamp", "gauge", "Unix time of the last completed scan.")
+	fmt.Fprintf(&buffer, "toxstatus_last_scan_timestamp %d\n", current.LastScan)
+
+	writeMetricHeader(&buffer, "toxstatus_node_up", "gauge", "Whether a bootstrap node responded over the probed transports.")
+	for _, node := range nodes {
+		up := 0
+		if isNodeUp(node) {
+			up = 1
+		}
+
+		fmt.Fprintf(&buffer, "toxstatus_node_up{public_key=\"%s\",maintainer=\"%s\"} %d\n",
+			labelValueReplacer.Replace(node.PublicKey),
+			labelValueReplacer.Replace(node.Maintainer),
+			up,
+		)
+	}
+
+	writeLatencyHistogram(&buffer, nodes)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buffer.Bytes())
+}
+
+//buckets the udp latencies of the last scan, nodes that didn't respond aren't counted
+func writeLatencyHistogram(buffer *bytes.Buffer, nodes []toxNode) {
+	counts := make([]int, len(latencyBuckets))
+	count := 0
+	sum := 0.0
+	for _, node := range nodes {
+		if node.LatencyMS < 0 {
+			continue
+		}
+
+		latency := float64(node.LatencyMS) / 1000
+		for i, bound := range latencyBuckets {
+			if latency <= bound {
+				counts[i]++
+			}
+		}
+		count++
+		sum += latency
+	}
+
+	writeMetricHeader(buffer, "toxstatus_probe_latency_seconds", "histogram", "UDP probe latencies of the bootstrap nodes during the last scan.")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(buffer, "toxstatus_probe_latency_seconds_bucket{le=\"%g\"} %d\n", bound, counts[i])
+	}
+	fmt.Fprintf(buffer, "toxstatus_probe_latency_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(buffer, "toxstatus_probe_latency_seconds_sum %g\n", sum)
+	fmt.Fprintf(buffer, "toxstatus_probe_latency_seconds_count %d\n", count)
+}
+
+func writeMetricHeader(buffer *bytes.Buffer, name string, metricType string, help string) {
+	fmt.Fprintf(buffer, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buffer, "# TYPE %s %s\n", name, metricType)
+}
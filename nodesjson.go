@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+//the node list format served by nodes.tox.chat/json, which tox clients use to find bootstrap nodes
+//field names and types must stay exactly as they are
+type nodesJSON struct {
+	LastScan    int64           `json:"last_scan"`    //toxStatus.LastScan
+	LastRefresh int64           `json:"last_refresh"` //toxStatus.LastSourceFetch
+	Nodes       []nodesJSONNode `json:"nodes"`
+}
+
+type nodesJSONNode struct {
+	Ipv4Address string `json:"ipv4"`       //"-" if the node has none
+	Ipv6Address string `json:"ipv6"`       //"-" if the node has none
+	Port        int    `json:"port"`       //udp port
+	TCPPorts    []int  `json:"tcp_ports"`  //ports a tcp handshake succeeded on, never null
+	PublicKey   string `json:"public_key"` //hex encoded
+	Maintainer  string `json:"maintainer"`
+	Location    string `json:"location"` //country code
+	UDPStatus   bool   `json:"status_udp"`
+	TCPStatus   bool   `json:"status_tcp"`
+	Version     string `json:"version"` //raw version number as a decimal string, not toxNode.Version
+	MOTD        string `json:"motd"`
+	LastPing    int64  `json:"last_ping"` //unix time, 0 if never seen
+}
+
+func handleNodesJSONRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	current := getStatus()
+	response := nodesJSON{
+		LastScan:    current.LastScan,
+		LastRefresh: current.LastSourceFetch,
+		Nodes:       make([]nodesJSONNode, len(current.Nodes)),
+	}
+
+	for i, node := range current.Nodes {
+		tcpPorts := node.TCPPorts
+		if tcpPorts == nil {
+			tcpPorts = []int{}
+		}
+
+		version := ""
+		if node.Version != "" {
+			version = fmt.Sprintf("%d", node.VersionRaw)
+		}
+
+		ipv4 := node.Ipv4Address
+		if !isAddressSet(ipv4) {
+			ipv4 = "-"
+		}
+
+		ipv6 := node.Ipv6Address
+		if !isAddressSet(ipv6) {
+			ipv6 = "-"
+		}
+
+		response.Nodes[i] = nodesJSONNode{
+			Ipv4Address: ipv4,
+			Ipv6Address: ipv6,
+			Port:        node.Port,
+			TCPPorts:    tcpPorts,
+			PublicKey:   node.PublicKey,
+			Maintainer:  node.Maintainer,
+			Location:    node.Location,
+			UDPStatus:   node.UDPStatus,
+			TCPStatus:   node.TCPStatus,
+			Version:     version,
+			MOTD:        node.MOTD,
+			LastPing:    node.LastPing,
+		}
+	}
+
+	writeJSON(w, r, response)
+}
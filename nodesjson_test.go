@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//json types as decoded into interface{}, by key
+var nodesJSONSchema = map[string]reflect.Kind{
+	"last_scan":    reflect.Float64,
+	"last_refresh": reflect.Float64,
+	"nodes":        reflect.Slice,
+}
+
+var nodesJSONNodeSchema = map[string]reflect.Kind{
+	"ipv4":       reflect.String,
+	"ipv6":       reflect.String,
+	"port":       reflect.Float64,
+	"tcp_ports":  reflect.Slice,
+	"public_key": reflect.String,
+	"maintainer": reflect.String,
+	"location":   reflect.String,
+	"status_udp": reflect.Bool,
+	"status_tcp": reflect.Bool,
+	"version":    reflect.String,
+	"motd":       reflect.String,
+	"last_ping":  reflect.Float64,
+}
+
+func checkSchema(t *testing.T, name string, value map[string]interface{}, schema map[string]reflect.Kind) {
+	keys := []string{}
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) != len(schema) {
+		t.Errorf("%s has the keys %v", name, keys)
+	}
+
+	for key, kind := range schema {
+		field, ok := value[key]
+		if !ok {
+			t.Errorf("%s is missing %s", name, key)
+		} else if field == nil || reflect.TypeOf(field).Kind() != kind {
+			t.Errorf("%s.%s is %#v instead of a %s", name, key, field, kind)
+		}
+	}
+}
+
+func TestNodesJSONSchema(t *testing.T) {
+	node := newToxNode("192.0.2.1", "", 33445, "ab", "alice", "US")
+	node.UDPStatus = true
+	node.Version = "0.2.18"
+	node.VersionRaw = 1000002018
+	publishStatus([]*toxNode{node}, 1, 1, 0)
+
+	recorder := httptest.NewRecorder()
+	handleNodesJSONRequest(recorder, httptest.NewRequest(http.MethodGet, "/nodes.json", nil))
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	checkSchema(t, "response", response, nodesJSONSchema)
+
+	nodes, _ := response["nodes"].([]interface{})
+	if len(nodes) != 1 {
+		t.Fatalf("expected one node, got %d", len(nodes))
+	}
+	checkSchema(t, "node", nodes[0].(map[string]interface{}), nodesJSONNodeSchema)
+
+	if ipv6 := nodes[0].(map[string]interface{})["ipv6"]; ipv6 != "-" {
+		t.Errorf("a missing address should be \"-\", got %q", ipv6)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//name of our own results in the global status
+const localVantage = "local"
+
+var (
+	peerURLs     []string     //json urls of other instances, see -peer
+	globalStatus atomic.Value //holds the globalView served on /json/global
+)
+
+//what a single vantage point saw of a node
+type vantageResult struct {
+	UDPStatus bool  `json:"status_udp"`
+	TCPStatus bool  `json:"status_tcp"`
+	LatencyMS int64 `json:"latency_ms"`
+}
+
+//a node as seen from every vantage point, it's online if any of them reached it
+type globalNode struct {
+	PublicKey   string                   `json:"public_key"`
+	Maintainer  string                   `json:"maintainer"`
+	Ipv4Address string                   `json:"ipv4"`
+	Ipv6Address string                   `json:"ipv6"`
+	Port        int                      `json:"port"`
+	Online      bool                     `json:"online"`
+	UDPStatus   bool                     `json:"status_udp"`
+	TCPStatus   bool                     `json:"status_tcp"`
+	Vantages    map[string]vantageResult `json:"vantages"`
+}
+
+type globalView struct {
+	LastScan int64             `json:"last_scan"`
+	Vantages []string          `json:"vantages"` //the ones that could be fetched
+	Errors   map[string]string `json:"errors"`   //why the others couldn't
+	Nodes    []*globalNode     `json:"nodes"`
+}
+
+//parses a comma separated list of peer urls
+func parsePeerURLs(s string) []string {
+	urls := []string{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			urls = append(urls, part)
+		}
+	}
+	return urls
+}
+
+func fetchPeerStatus(uri string) (toxStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *fetchTimeoutFlag)
+	defer cancel()
+
+	var peerStatus toxStatus
+	data, err := fetchSource(ctx, uri)
+	if err != nil {
+		return peerStatus, err
+	}
+
+	if err := json.Unmarshal(data, &peerStatus); err != nil {
+		return peerStatus, fmt.Errorf("invalid status from %s: %w", uri, err)
+	}
+	return peerStatus, nil
+}
+
+//fetches the results of the peers and merges them with ours into the global status
+func updateGlobalStatus(current toxStatus) {
+	statuses := make([]toxStatus, len(peerURLs))
+	errs := make([]error, len(peerURLs))
+
+	var wg sync.WaitGroup
+	for i, uri := range peerURLs {
+		wg.Add(1)
+		go func(i int, uri string) {
+			defer wg.Done()
+			statuses[i], errs[i] = fetchPeerStatus(uri)
+		}(i, uri)
+	}
+	wg.Wait()
+
+	view := globalView{LastScan: current.LastScan, Errors: map[string]string{}, Nodes: []*globalNode{}}
+	byKey := map[string]*globalNode{}
+	merge := func(vantage string, nodes []toxNode) {
+		view.Vantages = append(view.Vantages, vantage)
+		for _, node := range nodes {
+			key := strings.ToLower(node.PublicKey)
+			merged, ok := byKey[key]
+			if !ok {
+				merged = &globalNode{
+					PublicKey:   node.PublicKey,
+					Maintainer:  node.Maintainer,
+					Ipv4Address: node.Ipv4Address,
+					Ipv6Address: node.Ipv6Address,
+					Port:        node.Port,
+					Vantages:    map[string]vantageResult{},
+				}
+				byKey[key] = merged
+				view.Nodes = append(view.Nodes, merged)
+			}
+
+			merged.Vantages[vantage] = vantageResult{node.UDPStatus, node.TCPStatus, node.LatencyMS}
+			merged.UDPStatus = merged.UDPStatus || node.UDPStatus
+			merged.TCPStatus = merged.TCPStatus || node.TCPStatus
+			merged.Online = merged.Online || isNodeUp(node)
+		}
+	}
+
+	merge(localVantage, current.Nodes)
+	for i, uri := range peerURLs {
+		if errs[i] != nil {
+			slog.Warn("error fetching peer status", "peer", uri, "error", errs[i])
+			view.Errors[uri] = errs[i].Error()
+			continue
+		}
+		merge(uri, statuses[i].Nodes)
+	}
+
+	globalStatus.Store(view)
+}
+
+//serves our results merged with the ones of the peers
+func handleGlobalJSONRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	view, ok := globalStatus.Load().(globalView)
+	if !ok {
+		writeJSON(w, r, globalView{Vantages: []string{}, Errors: map[string]string{}, Nodes: []*globalNode{}})
+		return
+	}
+	writeJSON(w, r, view)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+//tcp connections to nodes go through this dialer when set, see -socks5
+var proxyDialer proxy.Dialer
+
+//socks5 proxies like tor don't relay udp, so those probes are skipped
+var errUDPOverProxy = errors.New("udp probes are skipped when probing through a socks5 proxy")
+
+func loadProxy(address string) error {
+	dialer, err := proxy.SOCKS5("tcp", address, nil, &net.Dialer{Timeout: *dialTimeoutFlag})
+	if err != nil {
+		return err
+	}
+
+	proxyDialer = dialer
+	return nil
+}
+
+//the proxy resolves the address itself, so hostnames and onion addresses work too
+func dialProxy(address string, deadline time.Time) (net.Conn, error) {
+	if dialer, ok := proxyDialer.(proxy.ContextDialer); ok {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	return proxyDialer.Dial("tcp", address)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthIsNotRateLimited(t *testing.T) {
+	handler := newRateLimiter(1, 1).limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	codes := map[string][]int{}
+	for i := 0; i < 3; i++ {
+		for _, target := range []string{"/health", "/json"} {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+			codes[target] = append(codes[target], recorder.Code)
+		}
+	}
+
+	for i, code := range codes["/health"] {
+		if code != http.StatusOK {
+			t.Errorf("health check %d: expected 200, got %d", i, code)
+		}
+	}
+	if last := codes["/json"][2]; last != http.StatusTooManyRequests {
+		t.Errorf("expected the other requests to be limited, got %v", codes["/json"])
+	}
+}
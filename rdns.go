@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rdnsTimeout = 2 * time.Second
+
+var (
+	rdnsCache = map[string]string{}
+	rdnsMutex sync.Mutex
+)
+
+//sets the hostname of the node from the ptr record of its address, if any
+func resolveHostname(node *toxNode) {
+	address := node.Ipv4Address
+	if net.ParseIP(address) == nil {
+		address = node.Ipv6Address
+	}
+
+	node.Hostname = lookupHostname(address)
+}
+
+//results are cached by ip, including failed lookups
+func lookupHostname(address string) string {
+	if net.ParseIP(address) == nil {
+		return ""
+	}
+
+	rdnsMutex.Lock()
+	hostname, ok := rdnsCache[address]
+	rdnsMutex.Unlock()
+	if ok {
+		return hostname
+	}
+
+	//don't hold the lock during the lookup, other workers may be waiting for theirs
+	ctx, cancel := context.WithTimeout(context.Background(), rdnsTimeout)
+	defer cancel()
+	if names, err := net.DefaultResolver.LookupAddr(ctx, address); err == nil && len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+
+	rdnsMutex.Lock()
+	rdnsCache[address] = hostname
+	rdnsMutex.Unlock()
+	return hostname
+}
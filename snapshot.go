@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//what is written to disk, the uptime histories aren't part of the json api so they're kept next to the nodes
+type nodesSnapshot struct {
+	Nodes     []toxNode                 `json:"nodes"`
+	Histories map[string]*uptimeHistory `json:"histories"` //by public key
+}
+
+func loadNodesSnapshot(path string) ([]*toxNode, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	//older snapshots are a bare list of nodes without histories
+	var snapshot nodesSnapshot
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &snapshot.Nodes)
+	} else {
+		err = json.Unmarshal(data, &snapshot)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := snapshot.Nodes
+	l := make([]*toxNode, len(nodes))
+	for i := range nodes {
+		nodes[i].history = snapshot.Histories[nodes[i].PublicKey]
+		l[i] = &nodes[i]
+	}
+
+	return l, nil
+}
+
+//returns the cached nodes along with the time they were fetched at
+func loadCachedNodes(path string) ([]*toxNode, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	nodes, err := loadNodesSnapshot(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return nodes, info.ModTime().Unix(), nil
+}
+
+//writes to a temporary file first so that a crash mid-write can't corrupt the snapshot
+func saveNodesSnapshot(path string, nodes []*toxNode) error {
+	snapshot := nodesSnapshot{copyNodes(nodes), map[string]*uptimeHistory{}}
+	for _, node := range nodes {
+		if node.history != nil {
+			snapshot.Histories[node.PublicKey] = node.history
+		}
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotKeepsUptimeHistory(t *testing.T) {
+	history := &uptimeHistory{}
+	for i := 0; i < uptimeWindow+10; i++ {
+		history.record(i%4 != 0)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	node := &toxNode{PublicKey: "ab", history: history}
+	if err := saveNodesSnapshot(path, []*toxNode{node, {PublicKey: "cd"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := loadNodesSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(nodes))
+	} else if *nodes[0].history != *history {
+		t.Error("uptime history changed in the snapshot")
+	} else if nodes[1].history != nil {
+		t.Error("node without history got one from the snapshot")
+	}
+}
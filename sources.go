@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+//the client node lists are fetched with, created once the flags are parsed
+var sourceClient *http.Client
+
+//a list of bootstrap nodes to probe
+type NodeSource interface {
+	Fetch(ctx context.Context) ([]*toxNode, error)
+}
+
+//the pipe delimited node table of the tox wiki
+type wikiSource struct {
+	uri string
+}
+
+//the json node list of nodes.tox.chat
+type jsonSource struct {
+	uri string
+}
+
+type jsonSourceNode struct {
+	Ipv4Address string `json:"ipv4"`
+	Ipv6Address string `json:"ipv6"`
+	Port        int    `json:"port"`
+	TCPPorts    []int  `json:"tcp_ports"`
+	PublicKey   string `json:"public_key"`
+	Maintainer  string `json:"maintainer"`
+	Location    string `json:"location"`
+}
+
+func (s wikiSource) String() string {
+	return s.uri
+}
+
+func (s wikiSource) Fetch(ctx context.Context) ([]*toxNode, error) {
+	content, err := fetchSource(ctx, s.uri)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []*toxNode{}
+	header := defaultWikiHeader
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		//every table of the page starts with its own header row
+		if strings.HasPrefix(strings.TrimSpace(line), "^") {
+			var err error
+			if header, err = parseWikiHeader(line); err != nil {
+				slog.Warn("skipping wiki table with an unexpected header", "line", line, "error", err)
+			}
+			continue
+		}
+
+		node := parseNode(line, header)
+		if node == nil {
+			continue
+		}
+
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+//the layout of a wiki table, mapping its normalized column names to their index in a row split on '|'
+type wikiHeader struct {
+	columns map[string]int
+	width   int  //number of parts of a row, 0 accepts the 8 or 9 of the default layout
+	skip    bool //the header was unusable, so are the rows below it
+}
+
+//used until a header row is found, an optional tcp ports column may follow the location
+var defaultWikiHeader = wikiHeader{
+	columns: map[string]int{"ipv4": 1, "ipv6": 2, "port": 3, "publickey": 4, "maintainer": 5, "location": 6, "tcpports": 7},
+}
+
+var requiredWikiColumns = []string{"ipv4", "ipv6", "port", "publickey"}
+
+//parses a header row like "^ IPv4 ^ IPv6 ^ Port ^ Public Key ^ Maintainer ^ Location ^"
+func parseWikiHeader(line string) (wikiHeader, error) {
+	parts := strings.Split(strings.ToLower(stripSpaces(line)), "^")
+	header := wikiHeader{columns: map[string]int{}, width: len(parts)}
+	for i, name := range parts {
+		if strings.HasPrefix(name, "tcp") {
+			name = "tcpports"
+		}
+		if name != "" {
+			header.columns[name] = i
+		}
+	}
+
+	for _, name := range requiredWikiColumns {
+		if _, ok := header.columns[name]; !ok {
+			return wikiHeader{skip: true}, fmt.Errorf("no %s column", name)
+		}
+	}
+	return header, nil
+}
+
+func (h wikiHeader) matches(parts int) bool {
+	if h.width == 0 {
+		return parts == 8 || parts == 9
+	}
+	return parts == h.width
+}
+
+//returns the named column of the row, or an empty string if the table doesn't have it
+func (h wikiHeader) get(parts []string, name string) string {
+	if i, ok := h.columns[name]; ok && i < len(parts) {
+		return parts[i]
+	}
+	return ""
+}
+
+func (s jsonSource) String() string {
+	return s.uri
+}
+
+func (s jsonSource) Fetch(ctx context.Context) ([]*toxNode, error) {
+	content, err := fetchSource(ctx, s.uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Nodes []jsonSourceNode `json:"nodes"`
+	}
+	if err := json.Unmarshal(content, &response); err != nil {
+		return nil, err
+	}
+
+	nodes := []*toxNode{}
+	for _, n := range response.Nodes {
+		publicKey, err := normalizePublicKey(n.PublicKey)
+		if err != nil {
+			slog.Warn("skipping node with an invalid public key", "source", s.uri, "public_key", n.PublicKey, "error", err)
+			continue
+		}
+
+		node := newToxNode(n.Ipv4Address, n.Ipv6Address, n.Port, publicKey, n.Maintainer, n.Location)
+		if n.TCPPorts != nil {
+			node.AdvertisedTCPPorts = n.TCPPorts
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func fetchSource(ctx context.Context, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", *userAgentFlag)
+	res, err := sourceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	//error pages would otherwise be parsed as an empty node list
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", uri, res.Status)
+	}
+
+	//reading one byte past the limit tells a list that is too big from one that fits exactly
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxSourceSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSourceSize {
+		return nil, fmt.Errorf("node list at %s is larger than %d bytes", uri, maxSourceSize)
+	}
+
+	return data, nil
+}
+
+//parses a comma separated list of source names
+func parseNodeSources(names string) ([]NodeSource, error) {
+	sources := []NodeSource{}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "wiki":
+			sources = append(sources, wikiSource{*wikiURLFlag})
+		case "json":
+			sources = append(sources, jsonSource{*jsonURLFlag})
+		default:
+			return nil, fmt.Errorf("unknown node source: %s", name)
+		}
+	}
+
+	return sources, nil
+}
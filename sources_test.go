@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchSourceErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html><body>down for maintenance</body></html>", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	sourceClient = server.Client()
+
+	if data, err := fetchSource(context.Background(), server.URL); err == nil {
+		t.Fatalf("expected an error for a 503, got %q", data)
+	}
+
+	if nodes, err := (wikiSource{server.URL}).Fetch(context.Background()); err == nil {
+		t.Fatalf("expected the wiki source to fail, got %d nodes", len(nodes))
+	}
+}
+
+func TestFetchSourceSizeLimit(t *testing.T) {
+	size := maxSourceSize
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, size))
+	}))
+	defer server.Close()
+	sourceClient = server.Client()
+
+	if data, err := fetchSource(context.Background(), server.URL); err != nil || len(data) != maxSourceSize {
+		t.Fatalf("expected a list of exactly the limit to be read, got %d bytes: %v", len(data), err)
+	}
+
+	size = maxSourceSize + 1
+	if _, err := fetchSource(context.Background(), server.URL); err == nil {
+		t.Fatal("expected an error for a list over the limit")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	historyLimit    = 1440
+	maxHistoryLimit = 10080
+)
+
+//only set if a database was configured
+var historyDB *sql.DB
+
+type historyEntry struct {
+	Timestamp int64 `json:"timestamp"`
+	UDPStatus bool  `json:"status_udp"`
+	TCPStatus bool  `json:"status_tcp"`
+	LatencyMS int64 `json:"latency_ms"`
+}
+
+func openHistoryDB(path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS scan_results (
+			timestamp  INTEGER NOT NULL,
+			public_key TEXT NOT NULL COLLATE NOCASE,
+			status_udp INTEGER NOT NULL,
+			status_tcp INTEGER NOT NULL,
+			latency_ms INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS scan_results_public_key ON scan_results (public_key, timestamp);
+	`)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	historyDB = db
+	return nil
+}
+
+//stores the results of a scan in a single transaction
+func recordScan(timestamp int64, nodes []*toxNode) error {
+	tx, err := historyDB.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO scan_results (timestamp, public_key, status_udp, status_tcp, latency_ms) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, node := range nodes {
+		if _, err := stmt.Exec(timestamp, node.PublicKey, node.UDPStatus, node.TCPStatus, node.LatencyMS); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//serves the most recent scan results of a node, newest first
+func handleHistoryRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	publicKey := r.URL.Query().Get("public_key")
+	if publicKey == "" {
+		http.Error(w, "missing public_key parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit := historyLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := historyDB.Query("SELECT timestamp, status_udp, status_tcp, latency_ms FROM scan_results WHERE public_key = ? ORDER BY timestamp DESC LIMIT ?", publicKey, limit)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	defer rows.Close()
+
+	entries := []historyEntry{}
+	for rows.Next() {
+		var entry historyEntry
+		if err := rows.Scan(&entry.Timestamp, &entry.UDPStatus, &entry.TCPStatus, &entry.LatencyMS); err != nil {
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	if rows.Err() != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	writeJSON(w, r, entries)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func isTLSEnabled() bool {
+	return *tlsCertFlag != "" || *autocertDomainFlag != ""
+}
+
+func validateTLSFlags() error {
+	if (*tlsCertFlag == "") != (*tlsKeyFlag == "") {
+		return errors.New("-tls-cert and -tls-key must be used together")
+	} else if *tlsCertFlag != "" && *autocertDomainFlag != "" {
+		return errors.New("-autocert-domain can't be combined with -tls-cert")
+	} else if *autocertDomainFlag != "" && len(parseAutocertDomains(*autocertDomainFlag)) == 0 {
+		return errors.New("-autocert-domain contains no domains")
+	} else if *httpRedirectFlag != 0 && !isTLSEnabled() {
+		return errors.New("-http-redirect requires tls to be enabled")
+	} else if *httpRedirectFlag < 0 || *httpRedirectFlag > 65535 {
+		return fmt.Errorf("invalid http redirect port: %d", *httpRedirectFlag)
+	}
+
+	return nil
+}
+
+//parses a comma separated list of domains, the whitelist only matches them exactly
+func parseAutocertDomains(s string) []string {
+	domains := []string{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			domains = append(domains, part)
+		}
+	}
+	return domains
+}
+
+//serves plain http, or https if a certificate or autocert domain was given
+func listenAndServe(handler http.Handler) error {
+	address := getListenAddress(*httpPortFlag)
+	if !isTLSEnabled() {
+		return http.ListenAndServe(address, handler)
+	}
+
+	server := &http.Server{Addr: address, Handler: handler}
+	var redirect http.Handler = http.HandlerFunc(redirectToHTTPS)
+	if *autocertDomainFlag != "" {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(*autocertCacheFlag),
+			HostPolicy: autocert.HostWhitelist(parseAutocertDomains(*autocertDomainFlag)...),
+		}
+		server.TLSConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(redirect) //also answers the http-01 challenges
+	}
+
+	if *httpRedirectFlag != 0 {
+		go func() {
+			err := http.ListenAndServe(getListenAddress(*httpRedirectFlag), redirect)
+			fatal("http redirect server stopped", "error", err)
+		}()
+	}
+
+	//the certificate paths are empty when autocert provides them
+	return server.ListenAndServeTLS(*tlsCertFlag, *tlsKeyFlag)
+}
+
+//the host part of -bind, empty listens on all interfaces
+var bindHost string
+
+//-bind may carry a port of its own, which then replaces -http-port
+func parseBindFlag() error {
+	host, port, err := net.SplitHostPort(*bindFlag)
+	if err != nil {
+		bindHost = strings.TrimSuffix(strings.TrimPrefix(*bindFlag, "["), "]")
+		return nil
+	}
+
+	if *httpPortFlag, err = strconv.Atoi(port); err != nil {
+		return fmt.Errorf("invalid bind port: %s", port)
+	}
+	bindHost = host
+	return nil
+}
+
+func getListenAddress(port int) string {
+	return net.JoinHostPort(bindHost, strconv.Itoa(port))
+}
+
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	//the host may be an ipv6 literal, which is bracketed with or without a port
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	if *httpPortFlag != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(*httpPortFlag))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
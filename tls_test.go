@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestIPv6ListenAddress(t *testing.T) {
+	oldBind, oldHost, oldPort := *bindFlag, bindHost, *httpPortFlag
+	defer func() { *bindFlag, bindHost, *httpPortFlag = oldBind, oldHost, oldPort }()
+
+	for _, bind := range []string{"::1", "[::1]"} {
+		*bindFlag = bind
+		if err := parseBindFlag(); err != nil {
+			t.Fatal(err)
+		}
+		if address := getListenAddress(8081); address != "[::1]:8081" {
+			t.Errorf("%s: expected [::1]:8081, got %s", bind, address)
+		}
+	}
+
+	*bindFlag = "[::1]:9000"
+	if err := parseBindFlag(); err != nil {
+		t.Fatal(err)
+	}
+	if address := getListenAddress(*httpPortFlag); address != "[::1]:9000" {
+		t.Errorf("expected [::1]:9000, got %s", address)
+	}
+}
+
+func TestIPv6Redirect(t *testing.T) {
+	oldPort := *httpPortFlag
+	defer func() { *httpPortFlag = oldPort }()
+
+	tests := []struct {
+		host     string
+		port     int
+		expected string
+	}{
+		{"[2001:db8::1]:80", 443, "https://[2001:db8::1]/json"},
+		{"[2001:db8::1]", 443, "https://[2001:db8::1]/json"},
+		{"[2001:db8::1]:80", 8443, "https://[2001:db8::1]:8443/json"},
+		{"192.0.2.1:80", 8443, "https://192.0.2.1:8443/json"},
+	}
+
+	for _, test := range tests {
+		*httpPortFlag = test.port
+		request := httptest.NewRequest(http.MethodGet, "/json", nil)
+		request.Host = test.host
+		recorder := httptest.NewRecorder()
+		redirectToHTTPS(recorder, request)
+
+		location := recorder.Header().Get("Location")
+		if location != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.host, test.expected, location)
+		}
+	}
+}
+
+func TestIPv6NodeConn(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no ipv6 loopback:", err)
+	}
+	defer listener.Close()
+
+	conn, err := newNodeConn("::1", listener.Addr().(*net.TCPAddr).Port, "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestParseAutocertDomains(t *testing.T) {
+	domains := parseAutocertDomains("a.org, b.org ,,c.org,")
+	if fmt.Sprint(domains) != "[a.org b.org c.org]" {
+		t.Errorf("expected the trimmed domains, got %q", domains)
+	}
+
+	policy := autocert.HostWhitelist(domains...)
+	if err := policy(context.Background(), "b.org"); err != nil {
+		t.Errorf("expected b.org to be whitelisted: %s", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const uptimeWindow = 1440 //in scans, about a day with the default refresh rate
+
+//ring buffer of the most recent probe results of a node
+type uptimeHistory struct {
+	results [uptimeWindow]bool
+	next    int
+	count   int
+}
+
+func (h *uptimeHistory) record(up bool) {
+	h.results[h.next] = up
+	h.next = (h.next + 1) % uptimeWindow
+	if h.count < uptimeWindow {
+		h.count++
+	}
+}
+
+//returns a copy that can be recorded into without touching h, nil stays nil
+func (h *uptimeHistory) clone() *uptimeHistory {
+	if h == nil {
+		return nil
+	}
+
+	clone := *h
+	return &clone
+}
+
+//how a history is stored in the snapshot, results are packed into bits
+type storedUptimeHistory struct {
+	Results []byte `json:"results"`
+	Next    int    `json:"next"`
+	Count   int    `json:"count"`
+}
+
+func (h *uptimeHistory) MarshalJSON() ([]byte, error) {
+	stored := storedUptimeHistory{make([]byte, (uptimeWindow+7)/8), h.next, h.count}
+	for i, up := range h.results {
+		if up {
+			stored.Results[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return json.Marshal(stored)
+}
+
+func (h *uptimeHistory) UnmarshalJSON(data []byte) error {
+	var stored storedUptimeHistory
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	if len(stored.Results) != (uptimeWindow+7)/8 || stored.Next < 0 || stored.Next >= uptimeWindow || stored.Count < 0 || stored.Count > uptimeWindow {
+		return fmt.Errorf("invalid uptime history of %d bytes with next %d and count %d", len(stored.Results), stored.Next, stored.Count)
+	}
+
+	for i := range h.results {
+		h.results[i] = stored.Results[i/8]&(1<<uint(i%8)) != 0
+	}
+	h.next = stored.Next
+	h.count = stored.Count
+	return nil
+}
+
+func (h *uptimeHistory) percent() float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	up := 0
+	for i := 0; i < h.count; i++ {
+		if h.results[i] {
+			up++
+		}
+	}
+
+	return float64(up) / float64(h.count) * 100
+}
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"container/list"
+	"encoding/hex"
 	"fmt"
+	"math/rand"
 	"strings"
 	"time"
 	"unicode"
@@ -17,24 +18,45 @@ func stripSpaces(s string) string {
 	}, s)
 }
 
+//lowercases the public key and makes sure it's 32 bytes of hex
+func normalizePublicKey(publicKey string) (string, error) {
+	publicKey = strings.ToLower(strings.TrimSpace(publicKey))
+	if len(publicKey) != 64 {
+		return "", fmt.Errorf("public key has %d characters instead of 64", len(publicKey))
+	}
+	if _, err := hex.DecodeString(publicKey); err != nil {
+		return "", err
+	}
+	return publicKey, nil
+}
+
 //lists can't be marshalled to json objects as easily
-func nodesListToSlice(l *list.List) []toxNode {
-	nodes := make([]toxNode, nodesList.Len())
-
-	i := 0
-	for e := nodesList.Front(); e != nil; e = e.Next() {
-		node, _ := e.Value.(*toxNode)
-		//while we're at it, let's update the last ping string!
-		if node.LastPing != 0 {
-			node.LastPingString = getSimpleDurationFormat(time.Now().Sub(time.Unix(node.LastPing, 0)))
-		}
+//returns copies of the nodes that can be handed out without sharing them with the scanner
+func copyNodes(l []*toxNode) []toxNode {
+	nodes := make([]toxNode, len(l))
+	for i, node := range l {
 		nodes[i] = *node
-		i++
 	}
 
 	return nodes
 }
 
+//returns the nodes of the list in a random order, the list itself is left untouched
+func shuffleNodes(l []*toxNode) []*toxNode {
+	nodes := append([]*toxNode{}, l...)
+
+	rand.Shuffle(len(nodes), func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	return nodes
+}
+
+//spreads a duration randomly by up to -jitter of its length either way, so timeouts
+//and retries of nodes that were probed at the same time don't stay in lockstep
+func addJitter(duration time.Duration) time.Duration {
+	return duration + time.Duration((rand.Float64()*2-1)**jitterFlag*float64(duration))
+}
+
 func getSimpleDurationFormat(duration time.Duration) string {
 	hours := duration.Hours()
 	var format string
@@ -56,6 +78,64 @@ func getSimpleDurationFormat(duration time.Duration) string {
 	return format
 }
 
+//the node list uses "-" or "NONE" for missing addresses
+func isAddressSet(address string) bool {
+	return address != "" && address != "-" && address != "NONE"
+}
+
+//strips control characters and invalid utf-8 from a motd and cuts it to a displayable length
+func sanitizeMOTD(motd string) string {
+	motd = strings.ToValidUTF8(motd, "")
+	motd = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' {
+			return -1
+		}
+		return r
+	}, motd)
+
+	runes := []rune(strings.TrimSpace(motd))
+	if len(runes) > maxMOTDDisplayLength {
+		return string(runes[:maxMOTDDisplayLength]) + "…"
+	}
+	return string(runes)
+}
+
+//decodes the version number of a bootstrap info packet
+//toxcore encodes its version as 1MMMmmmppp, older bootstrap daemons used yyyymmddvv
+func formatVersion(version uint32) string {
+	if version < 1000 {
+		return fmt.Sprintf("%d", version)
+	}
+
+	if version >= 1000000000 && version < 2000000000 {
+		return fmt.Sprintf("%d.%d.%d", version/1000000%1000, version/1000%1000, version%1000)
+	}
+
+	year := version / 1000000
+	month := version / 10000 % 100
+	day := version / 100 % 100
+	if year >= 2013 && year <= 2099 && month >= 1 && month <= 12 && day >= 1 && day <= 31 {
+		return fmt.Sprintf("%04d-%02d-%02d rev %d", year, month, day, version%100)
+	}
+
+	return "unknown"
+}
+
+//checks whether an If-None-Match header value matches the given etag
+func matchesETag(header string, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if value == etag || value == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func formatDate(timestamp int64) string {
+	return time.Unix(timestamp, 0).Format("2006-01-02")
+}
+
 func contains(ints []int, q int) bool {
 	for _, i := range ints {
 		if i == q {
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizePublicKey(t *testing.T) {
+	valid := strings.Repeat("0123456789abcdef", 4)
+
+	tests := []struct {
+		key      string
+		expected string
+		valid    bool
+	}{
+		{valid, valid, true},
+		{" " + strings.ToUpper(valid) + "\t", valid, true},
+		{valid[:63], "", false},
+		{"", "", false},
+		{valid + "0", "", false},
+		{strings.Repeat("g", 64), "", false},
+		{valid[:62] + "-1", "", false},
+	}
+
+	for _, test := range tests {
+		key, err := normalizePublicKey(test.key)
+		if test.valid && (err != nil || key != test.expected) {
+			t.Errorf("%q: expected %q, got %q and %v", test.key, test.expected, key, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%q: expected an error, got %q", test.key, key)
+		}
+	}
+}
+
+func TestFormatVersion(t *testing.T) {
+	tests := []struct {
+		version  uint32
+		expected string
+	}{
+		{0, "0"},
+		{2, "2"},
+		{1000002018, "0.2.18"},
+		{1000001003, "0.1.3"},
+		{1001002003, "1.2.3"},
+		{2014052001, "2014-05-20 rev 1"},
+		{2016122999, "2016-12-29 rev 99"},
+		{2014130001, "unknown"},
+		{999999999, "unknown"},
+		{4294967295, "unknown"},
+	}
+
+	for _, test := range tests {
+		if version := formatVersion(test.version); version != test.expected {
+			t.Errorf("%d: expected %q, got %q", test.version, test.expected, version)
+		}
+	}
+}
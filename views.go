@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+//serves the nodes of the current snapshot grouped by maintainer
+func handleMaintainersJSONRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	maintainers := map[string][]toxNode{}
+	for _, node := range getStatus().Nodes {
+		maintainer := strings.Join(strings.Fields(node.Maintainer), " ")
+		maintainers[maintainer] = append(maintainers[maintainer], node)
+	}
+
+	writeJSON(w, r, maintainers)
+}
+
+//serves the current snapshot as a space aligned table for shell tools
+func handleTextRequest(w http.ResponseWriter, r *http.Request) {
+	var builder strings.Builder
+	table := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PUBLIC_KEY\tIPV4\tPORT\tSTATUS\tLATENCY_MS")
+	for _, node := range getStatus().Nodes {
+		state := "offline"
+		if isNodeUp(node) {
+			state = "online"
+		}
+
+		latency := "-"
+		if node.LatencyMS >= 0 {
+			latency = strconv.FormatInt(node.LatencyMS, 10)
+		}
+
+		ipv4 := node.Ipv4Address
+		if !isAddressSet(ipv4) {
+			ipv4 = "-"
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n", node.PublicKey, ipv4, node.Port, state, latency)
+	}
+	table.Flush()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeCompressed(w, r, []byte(builder.String()))
+}
+
+//serves the current snapshot as a csv file for spreadsheets
+func handleCSVRequest(w http.ResponseWriter, r *http.Request) {
+	var builder strings.Builder
+	writer := csv.NewWriter(&builder)
+	writer.Write([]string{"public_key", "ipv4", "ipv6", "port", "status", "latency_ms", "maintainer", "location", "version"})
+	for _, node := range getStatus().Nodes {
+		state := "offline"
+		if isNodeUp(node) {
+			state = "online"
+		}
+
+		latency := ""
+		if node.LatencyMS >= 0 {
+			latency = strconv.FormatInt(node.LatencyMS, 10)
+		}
+
+		writer.Write([]string{
+			node.PublicKey,
+			node.Ipv4Address,
+			node.Ipv6Address,
+			strconv.Itoa(node.Port),
+			state,
+			latency,
+			node.Maintainer,
+			node.Location,
+			node.Version,
+		})
+	}
+	writer.Flush()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="tox_nodes.csv"`)
+	writeCompressed(w, r, []byte(builder.String()))
+}
+
+//serves the public keys of the online nodes, or of all nodes with ?status=all
+func handleKeysRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	nodes := getStatus().Nodes
+	if r.URL.Query().Get("status") != "all" {
+		nodes = filterOnlineNodes(nodes)
+	}
+
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.PublicKey
+	}
+
+	writeJSON(w, r, keys)
+}
+
+//serves the node with the public key at the end of the path
+func handleNodeJSONRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	publicKey := strings.TrimPrefix(r.URL.Path, "/json/node/")
+	for _, node := range getStatus().Nodes {
+		if strings.EqualFold(node.PublicKey, publicKey) {
+			writeJSON(w, r, node)
+			return
+		}
+	}
+
+	http.Error(w, http.StatusText(404), 404)
+}
+
+//aggregate health of the network, computed from the current snapshot
+type statusSummary struct {
+	Total         int     `json:"total"`
+	OnlineUDP     int     `json:"online_udp"`
+	OnlineTCP     int     `json:"online_tcp"`
+	OnlineIPv6    int     `json:"online_ipv6"`
+	Online        int     `json:"online"`         //nodes that are up over the probed transports, see isNodeUp
+	HealthPercent float64 `json:"health_percent"` //share of the nodes that are online
+	LastScan      int64   `json:"last_scan"`
+}
+
+func summarizeStatus(current toxStatus) statusSummary {
+	summary := statusSummary{Total: len(current.Nodes), LastScan: current.LastScan}
+	for _, node := range current.Nodes {
+		if isNodeUp(node) {
+			summary.Online++
+		}
+		if node.UDPStatus {
+			summary.OnlineUDP++
+		}
+		if node.TCPStatus {
+			summary.OnlineTCP++
+		}
+		if node.UDPStatusIpv6 || node.TCPStatusIpv6 {
+			summary.OnlineIPv6++
+		}
+	}
+
+	if summary.Total > 0 {
+		summary.HealthPercent = float64(summary.Online) / float64(summary.Total) * 100
+	}
+	return summary
+}
+
+func handleSummaryJSONRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	writeCachedJSON(w, r, "json:summary", func(current toxStatus) interface{} {
+		return summarizeStatus(current)
+	})
+}
+
+//node counts of a country, see handleCountriesJSONRequest
+type countrySummary struct {
+	Country string `json:"country"`
+	Total   int    `json:"total"`
+	Online  int    `json:"online"`
+}
+
+//nodes without a location are counted under "unknown"
+func summarizeCountries(current toxStatus) map[string]*countrySummary {
+	summaries := map[string]*countrySummary{}
+	for _, node := range current.Nodes {
+		code := strings.ToUpper(strings.TrimSpace(node.Location))
+		if code == "" {
+			code = "unknown"
+		}
+
+		summary, ok := summaries[code]
+		if !ok {
+			summary = &countrySummary{Country: countries[code]}
+			summaries[code] = summary
+		}
+
+		summary.Total++
+		if isNodeUp(node) {
+			summary.Online++
+		}
+	}
+
+	return summaries
+}
+
+//serves the total and online node counts per country code
+func handleCountriesJSONRequest(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r) {
+		return
+	}
+
+	writeCachedJSON(w, r, "json:by-country", func(current toxStatus) interface{} {
+		return summarizeCountries(current)
+	})
+}
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="110" height="20" role="img" aria-label="tox nodes: %[1]s">` +
+	`<rect width="65" height="20" fill="#555"/><rect x="65" width="45" height="20" fill="%[2]s"/>` +
+	`<g fill="#fff" text-anchor="middle" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">` +
+	`<text x="32.5" y="14">tox nodes</text><text x="87.5" y="14">%[1]s</text></g></svg>`
+
+//serves a shields.io style svg badge with the health percent of the network
+func handleBadgeRequest(w http.ResponseWriter, r *http.Request) {
+	data, _ := getCachedResponse("badge", func(current toxStatus) ([]byte, error) {
+		summary := summarizeStatus(current)
+
+		color := "#e05d44"
+		if summary.HealthPercent >= 80 {
+			color = "#4c1"
+		} else if summary.HealthPercent >= 50 {
+			color = "#dfb317"
+		}
+
+		return []byte(fmt.Sprintf(badgeTemplate, fmt.Sprintf("%.0f%%", summary.HealthPercent), color)), nil
+	})
+
+	//badges are usually embedded through image proxies that would otherwise keep them for long
+	w.Header().Set("Cache-Control", "no-cache")
+	writeWithETag(w, r, data, "image/svg+xml")
+}
+
+//bare status page served when the template couldn't be found in the assets
+var fallbackTemplate = template.Must(template.New("fallback").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Tox Node Status</title></head>
+<body>
+<p>The status page assets were not found, run with -headless or set -assets-dir. The nodes are also available at <a href="/json">/json</a>.</p>
+<p>Last scan: {{.LastScanString}}, {{.NodesOnline}}/{{.NodesTotal}} nodes online.</p>
+<table>
+<tr><th>IPv4</th><th>IPv6</th><th>Port</th><th>Public key</th><th>Maintainer</th><th>UDP</th><th>TCP</th></tr>
+{{range .Nodes}}<tr><td>{{.Ipv4Address}}</td><td>{{.Ipv6Address}}</td><td>{{.Port}}</td><td>{{.PublicKey}}</td><td>{{.Maintainer}}</td><td>{{if .UDPStatus}}up{{else}}down{{end}}</td><td>{{if .TCPStatus}}up{{else}}down{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+//a sorted slice of the nodes of a status, Total is the number of nodes before slicing
+type statusPage struct {
+	toxStatus
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+//orders nodes by how reachable they are, online before relay only before offline
+func getNodeStatusRank(node toxNode) int {
+	if isNodeUp(node) {
+		return 0
+	} else if node.TCPStatus {
+		return 1
+	}
+	return 2
+}
+
+//nodes without a known latency are sorted as the slowest
+func getSortLatency(node toxNode) int64 {
+	if node.LatencyMS < 0 {
+		return math.MaxInt64
+	}
+	return node.LatencyMS
+}
+
+var nodeSorters = map[string]func(a toxNode, b toxNode) bool{
+	"latency": func(a toxNode, b toxNode) bool { return getSortLatency(a) < getSortLatency(b) },
+	"uptime":  func(a toxNode, b toxNode) bool { return a.UptimePercent < b.UptimePercent },
+	"maintainer": func(a toxNode, b toxNode) bool {
+		return strings.ToLower(a.Maintainer) < strings.ToLower(b.Maintainer)
+	},
+	"status": func(a toxNode, b toxNode) bool { return getNodeStatusRank(a) < getNodeStatusRank(b) },
+}
+
+//applies the sort, order, offset and limit query parameters to the nodes of the status
+func paginateStatus(current toxStatus, query url.Values) (statusPage, error) {
+	//the snapshot is shared with every other request, so don't sort it in place
+	nodes := append([]toxNode(nil), current.Nodes...)
+	page := statusPage{Total: len(nodes), Limit: len(nodes)}
+
+	if name := query.Get("sort"); name != "" {
+		less, ok := nodeSorters[name]
+		if !ok {
+			return page, fmt.Errorf("unknown sort: %s", name)
+		}
+
+		switch query.Get("order") {
+		case "", "asc":
+		case "desc":
+			ascending := less
+			less = func(a toxNode, b toxNode) bool { return ascending(b, a) }
+		default:
+			return page, fmt.Errorf("unknown order: %s", query.Get("order"))
+		}
+
+		sort.SliceStable(nodes, func(i, j int) bool { return less(nodes[i], nodes[j]) })
+	}
+
+	var err error
+	if value := query.Get("offset"); value != "" {
+		if page.Offset, err = strconv.Atoi(value); err != nil || page.Offset < 0 {
+			return page, fmt.Errorf("invalid offset: %s", value)
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if page.Limit, err = strconv.Atoi(value); err != nil || page.Limit < 0 {
+			return page, fmt.Errorf("invalid limit: %s", value)
+		}
+	}
+
+	start := page.Offset
+	if start > len(nodes) {
+		start = len(nodes)
+	}
+	end := len(nodes)
+	if page.Limit < end-start {
+		end = start + page.Limit
+	}
+
+	current.Nodes = nodes[start:end]
+	page.toxStatus = current
+	return page, nil
+}
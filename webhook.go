@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+	webhookBackoff  = 2 * time.Second
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+//a node that went up or down between two scans
+type nodeTransition struct {
+	PublicKey  string `json:"public_key"`
+	Maintainer string `json:"maintainer"`
+	OldStatus  string `json:"old_status"`
+	NewStatus  string `json:"new_status"`
+}
+
+type webhookPayload struct {
+	Timestamp   int64            `json:"timestamp"`
+	Transitions []nodeTransition `json:"transitions"`
+}
+
+func getNodeStatusName(node *toxNode) string {
+	if isNodeUp(*node) {
+		return "online"
+	}
+	return "offline"
+}
+
+//returns the nodes whose status changed, nodes that are new to the list aren't transitions
+func findTransitions(oldNodes []*toxNode, newNodes []*toxNode) []nodeTransition {
+	transitions := []nodeTransition{}
+	for _, node := range newNodes {
+		oldNode := findNode(oldNodes, node.PublicKey)
+		if oldNode == nil || isNodeUp(*oldNode) == isNodeUp(*node) {
+			continue
+		}
+
+		transitions = append(transitions, nodeTransition{
+			node.PublicKey,
+			node.Maintainer,
+			getNodeStatusName(oldNode),
+			getNodeStatusName(node),
+		})
+	}
+	return transitions
+}
+
+//posts the transitions to the webhook, retrying a couple of times before giving up
+func sendWebhook(uri string, payload webhookPayload) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.Warn("error encoding webhook payload", "error", err)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(uri, data)
+		if err == nil {
+			return
+		} else if attempt >= webhookAttempts {
+			slog.Warn("error sending webhook", "attempts", attempt, "error", err)
+			return
+		}
+
+		time.Sleep(time.Duration(attempt) * webhookBackoff)
+	}
+}
+
+func postWebhook(uri string, data []byte) error {
+	res, err := webhookClient.Post(uri, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}